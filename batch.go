@@ -0,0 +1,153 @@
+package tsq
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// Batch is a group of jobs submitted together. Once all of them have
+// finished, the OnComplete task is submitted with their results.
+type Batch struct {
+	ID          string         `json:"id"`
+	OnComplete  string         `json:"onComplete,omitempty"`
+	CallbackJob string         `json:"callbackJob,omitempty"`
+	Created     time.Time      `json:"created"`
+	Counts      map[string]int `json:"counts"`
+	Jobs        []*Job         `json:"jobs"`
+}
+
+type BatchJob struct {
+	Task      string      `json:"task"`
+	Arguments interface{} `json:"arguments"`
+}
+
+// BatchResult is the outcome of a job of a batch, as passed to the
+// OnComplete task.
+type BatchResult struct {
+	UUID   string      `json:"uuid"`
+	Status string      `json:"status"`
+	Result interface{} `json:"result"`
+}
+
+// SubmitBatch stores all jobs of a batch at once and queues them. Batches are
+// not limited by QueueLength. When onComplete is not empty, that task is
+// submitted with a []BatchResult once all jobs of the batch have finished.
+func (q *TaskQueue) SubmitBatch(jobs []BatchJob, onComplete string) (batch *Batch, err error) {
+	if len(jobs) == 0 {
+		err = errors.New("A batch needs at least one job")
+		return
+	}
+	if _, ok := q.tasks[onComplete]; onComplete != "" && !ok {
+		err = errors.New("Unknown task: " + onComplete)
+		return
+	}
+	id, err := newUUID()
+	if err != nil {
+		return
+	}
+
+	batch = &Batch{
+		ID:         id,
+		OnComplete: onComplete,
+		Created:    time.Now(),
+		Jobs:       make([]*Job, 0, len(jobs)),
+	}
+	for _, batchJob := range jobs {
+		job, err := q.newJob(batchJob.Task, batchJob.Arguments, inBatch(id))
+		if err != nil {
+			return nil, err
+		}
+		batch.Jobs = append(batch.Jobs, job)
+	}
+
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
+	err = q.jobStore.StoreBatch(batch)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range batch.Jobs {
+		q.enqueue(job, job.Created, false)
+	}
+	batch.Counts = countStatuses(batch.Jobs)
+	return
+}
+
+// GetBatch returns a batch with the current state of its jobs.
+func (q *TaskQueue) GetBatch(id string) (batch *Batch, err error) {
+	batch, err = q.jobStore.GetBatch(id)
+	if err != nil {
+		return
+	}
+	batch.Jobs, err = q.jobStore.GetJobsByBatch(id)
+	if err != nil {
+		return
+	}
+	batch.Counts = countStatuses(batch.Jobs)
+	return
+}
+
+func inBatch(id string) SubmitOption {
+	return func(job *Job) {
+		job.Batch = id
+	}
+}
+
+// validateOnRun leaves checking the arguments of a job against the schema of
+// its task to execute.
+func validateOnRun(job *Job) {
+	job.validateOnRun = true
+}
+
+func countStatuses(jobs []*Job) map[string]int {
+	counts := make(map[string]int)
+	for _, job := range jobs {
+		counts[job.Status]++
+	}
+	return counts
+}
+
+// settleBatch submits the OnComplete task of the batch of a job once all jobs
+// of that batch have finished. The caller must hold q.jobMutex.
+func (q *TaskQueue) settleBatch(uuid string) {
+	job, err := q.jobStore.GetJob(uuid)
+	if err != nil || job.Batch == "" {
+		return
+	}
+	batch, err := q.jobStore.GetBatch(job.Batch)
+	if err != nil || batch.OnComplete == "" || batch.CallbackJob != "" {
+		return
+	}
+	q.completeBatch(batch)
+}
+
+// completeBatch submits the OnComplete task of a batch when all its jobs have
+// finished. A batch whose callback could not be submitted is tried again when
+// the queue starts. The caller must hold q.jobMutex.
+func (q *TaskQueue) completeBatch(batch *Batch) {
+	jobs, err := q.jobStore.GetJobsByBatch(batch.ID)
+	if err != nil {
+		return
+	}
+	results := make([]BatchResult, 0, len(jobs))
+	for _, job := range jobs {
+		if !job.HasFinished() {
+			return
+		}
+		results = append(results, BatchResult{job.UUID, job.Status, job.Result})
+	}
+
+	callback, err := q.newJob(batch.OnComplete, results, validateOnRun)
+	if err == nil {
+		err = q.jobStore.Store(callback)
+	}
+	if err == nil {
+		err = q.jobStore.SetBatchCallback(batch.ID, callback.UUID)
+	}
+	if err != nil {
+		log.Println("Failed to complete batch " + batch.ID + ": " + err.Error())
+		return
+	}
+	q.enqueue(callback, callback.Created, false)
+}
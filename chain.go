@@ -0,0 +1,121 @@
+package tsq
+
+import (
+	"errors"
+)
+
+// Chain is a sequence of jobs in which every job gets the result of the
+// previous one as its arguments. The chain stops at the first job that does
+// not succeed.
+type Chain struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Jobs   []*Job `json:"jobs"`
+}
+
+// SubmitChain submits a job for every task in tasks. The first job gets
+// arguments, the next ones the result of the job before them. The jobs are
+// stored together, so that either the whole chain is submitted or none of it.
+func (q *TaskQueue) SubmitChain(tasks []string, arguments interface{}) (chain *Chain, err error) {
+	if len(tasks) == 0 {
+		err = errors.New("A chain needs at least one task")
+		return
+	}
+	workflow, err := q.NewWorkflow()
+	if err != nil {
+		return
+	}
+
+	head, err := q.newJob(tasks[0], arguments, inWorkflow(workflow.ID))
+	if err != nil {
+		return nil, err
+	}
+	jobs := []*Job{head}
+	for _, name := range tasks[1:] {
+		previous := jobs[len(jobs)-1]
+		job, err := q.newJob(name, nil, inWorkflow(workflow.ID), DependsOn(previous.UUID), argumentsFrom(previous.UUID))
+		if err != nil {
+			return nil, err
+		}
+		job.Status = JOB_WAITING
+		jobs = append(jobs, job)
+	}
+
+	slots := q.tasks[head.Name].lane.slots
+	select {
+	case slots <- true:
+	default:
+		return nil, ErrQueueFull
+	}
+	err = q.jobStore.StoreJobs(jobs)
+	if err != nil {
+		<-slots
+		return nil, err
+	}
+	q.jobMutex.Lock()
+	// Jobs of the chain may have been cancelled since they were stored.
+	if q.cancelled[head.UUID] {
+		q.forget(head.UUID)
+		<-slots
+	} else {
+		q.enqueue(head, head.RunAt, true)
+	}
+	for _, job := range jobs[1:] {
+		if q.cancelled[job.UUID] {
+			q.forget(job.UUID)
+			continue
+		}
+		q.waiting[job.UUID] = job
+		q.resolve(job)
+	}
+	q.jobMutex.Unlock()
+
+	chain = &Chain{ID: workflow.ID, Status: chainStatus(jobs), Jobs: jobs}
+	return
+}
+
+// GetChain returns a chain with the current state of its jobs.
+func (q *TaskQueue) GetChain(id string) (chain *Chain, err error) {
+	jobs, err := q.GetWorkflow(id)
+	if err != nil {
+		return
+	}
+	if len(jobs) == 0 {
+		err = errors.New("Chain " + id + " not found")
+		return
+	}
+	chain = &Chain{ID: id, Status: chainStatus(jobs), Jobs: jobs}
+	return
+}
+
+func argumentsFrom(uuid string) SubmitOption {
+	return func(job *Job) {
+		job.ArgumentsFrom = uuid
+	}
+}
+
+// chainStatus is the status of the first job that did not succeed, or
+// SUCCESS when all jobs did. An unfinished chain is RUNNING once any of its
+// jobs started and PENDING before that.
+func chainStatus(jobs []*Job) string {
+	started := false
+	for _, job := range jobs {
+		switch job.Status {
+		case JOB_SUCCESS:
+			started = true
+		case JOB_FAILURE, JOB_CANCELLED, JOB_TIMEOUT, JOB_SKIPPED:
+			return job.Status
+		case JOB_RUNNING, JOB_RETRYING:
+			return JOB_RUNNING
+		}
+	}
+	if !started {
+		return JOB_PENDING
+	}
+	for _, job := range jobs {
+		if job.Status != JOB_SUCCESS {
+			return JOB_RUNNING
+		}
+	}
+	return JOB_SUCCESS
+}
@@ -2,6 +2,7 @@ package tsq
 
 import (
 	"bytes"
+	"context"
 	"os/exec"
 )
 
@@ -18,9 +19,14 @@ func NewCommandTask(Cmd string, Args ...string) CommandTask {
 }
 
 func (t *CommandTask) Run(arguments interface{}) (data interface{}, err error) {
+	return t.RunContext(context.Background(), arguments)
+}
+
+// RunContext runs the command and kills it when ctx is done.
+func (t *CommandTask) RunContext(ctx context.Context, arguments interface{}) (data interface{}, err error) {
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
-	cmd := exec.Command(t.Cmd, t.Args...)
+	cmd := exec.CommandContext(ctx, t.Cmd, t.Args...)
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
@@ -1,25 +1,85 @@
 package tsq
 
+import (
+	"context"
+	"time"
+)
+
 type Config struct {
-	QueueLength int
-	JobStore    JobStore
+	QueueLength    int
+	Workers        int
+	JobStore       JobStore
+	RecoveryPolicy RecoveryPolicy
+	// PriorityAging is how long a pending job has to wait to gain the same
+	// precedence as a job with a priority of one more.
+	PriorityAging time.Duration
+	// IdempotencyRetention is how long the HTTP server remembers the
+	// Idempotency-Key of a submission.
+	IdempotencyRetention time.Duration
+	// Queues are named queues with their own workers and queue length, in
+	// addition to DefaultQueue. Tasks are assigned to them with InQueue.
+	Queues map[string]QueueConfig
 }
 
+// RecoveryPolicy decides what happens on Start to jobs that were running when
+// the queue stopped.
+type RecoveryPolicy int
+
+const (
+	// RECOVER_FAIL marks interrupted jobs as failed.
+	RECOVER_FAIL RecoveryPolicy = iota
+	// RECOVER_REQUEUE runs interrupted jobs again.
+	RECOVER_REQUEUE
+)
+
 func (config *Config) NewQueue() (q *TaskQueue) {
+	lanes := map[string]*lane{
+		DefaultQueue: newLane(DefaultQueue, QueueConfig{config.QueueLength, config.Workers}),
+	}
+	for name, queueConfig := range config.Queues {
+		if name != DefaultQueue {
+			lanes[name] = newLane(name, queueConfig)
+		}
+	}
+	workers := 0
+	for _, l := range lanes {
+		workers += l.workers
+	}
+	events := newEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
 	q = &TaskQueue{
 		stopQueue: make(chan bool, 1),
-		tasks:     make(map[string]Runner),
-		jobQueue:  make(chan *Job, config.getQueueLength()),
-		jobStore:  config.getJobStore(),
+		closed:    make(chan bool),
+		tasks:     make(map[string]*task),
+		lanes:     lanes,
+		wakeup:    make(chan bool, 1),
+		finished:  make(chan *Job, workers),
+		jobStore:  &eventStore{config.getJobStore(), events},
+		events:    events,
+		aging:     config.getPriorityAging(),
+		recovery:  config.RecoveryPolicy,
+		retention: config.getIdempotencyRetention(),
+		ctx:       ctx,
+		cancel:    cancel,
+
+		executions: make(map[string]context.CancelFunc),
+		cancelled:  make(map[string]bool),
+		waiting:    make(map[string]*Job),
+
+		schedules: make(map[string]*Schedule),
 	}
 	return
 }
 
+// getJobStore gives every queue without a configured store a MemoryStore of
+// its own, as Start recovers the jobs it finds in the store.
 func (config *Config) getJobStore() (store JobStore) {
 	if config.JobStore != nil {
 		store = config.JobStore
-	} else {
+	} else if DefaultConfig.JobStore != nil {
 		store = DefaultConfig.JobStore
+	} else {
+		store = NewMemoryStore()
 	}
 	return
 }
@@ -33,7 +93,36 @@ func (config *Config) getQueueLength() (queueLength int) {
 	return
 }
 
+func (config *Config) getWorkers() (workers int) {
+	if config.Workers > 0 {
+		workers = config.Workers
+	} else {
+		workers = DefaultConfig.Workers
+	}
+	return
+}
+
+func (config *Config) getPriorityAging() (aging time.Duration) {
+	if config.PriorityAging > 0 {
+		aging = config.PriorityAging
+	} else {
+		aging = DefaultConfig.PriorityAging
+	}
+	return
+}
+
+func (config *Config) getIdempotencyRetention() (retention time.Duration) {
+	if config.IdempotencyRetention > 0 {
+		retention = config.IdempotencyRetention
+	} else {
+		retention = DefaultConfig.IdempotencyRetention
+	}
+	return
+}
+
 var DefaultConfig Config = Config{
-	QueueLength: 10,
-	JobStore:    NewMemoryStore(),
+	QueueLength:          10,
+	Workers:              1,
+	PriorityAging:        time.Minute,
+	IdempotencyRetention: 24 * time.Hour,
 }
@@ -0,0 +1,143 @@
+package tsq
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed cron expression with the five standard fields:
+// minute, hour, day of month, month and day of week.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	anyDom, anyDow                bool
+}
+
+type cronField struct {
+	min, max int
+}
+
+var cronFields = []cronField{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 7},  // day of week, 0 and 7 are Sunday
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a cron expression such as "*/15 8-18 * * 1-5" or one of
+// the descriptors @yearly, @monthly, @weekly, @daily and @hourly.
+func ParseCron(spec string) (cron *CronSchedule, err error) {
+	if expanded, ok := cronDescriptors[spec]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		err = errors.New("Cron expression " + spec + " must have 5 fields")
+		return
+	}
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		bits[i], err = parseCronField(field, cronFields[i])
+		if err != nil {
+			err = errors.New("Cron expression " + spec + ": " + err.Error())
+			return
+		}
+	}
+	cron = &CronSchedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4] | bits[4]>>7, // fold 7 onto Sunday
+		anyDom: fields[2] == "*",
+		anyDow: fields[4] == "*",
+	}
+	return
+}
+
+func parseCronField(field string, bounds cronField) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				err = errors.New("invalid step in " + part)
+				return
+			}
+			part = part[:i]
+		}
+
+		start, end := bounds.min, bounds.max
+		if part != "*" {
+			values := strings.SplitN(part, "-", 2)
+			start, err = strconv.Atoi(values[0])
+			if err != nil {
+				err = errors.New("invalid value " + values[0])
+				return
+			}
+			switch {
+			case len(values) == 2:
+				end, err = strconv.Atoi(values[1])
+				if err != nil {
+					err = errors.New("invalid value " + values[1])
+					return
+				}
+			case step == 1:
+				end = start
+			}
+		}
+		if start < bounds.min || end > bounds.max || start > end {
+			err = errors.New(part + " is out of range")
+			return
+		}
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return
+}
+
+// Next returns the first time after t that matches the schedule, or the zero
+// time when no such time exists within the next five years.
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay follows cron in running when either the day of month or the day
+// of week matches if both are restricted.
+func (c *CronSchedule) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,44 @@
+package tsq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2017, 1, 13, 11, 10, 30, 0, time.UTC)
+	cases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2017, 1, 13, 11, 11, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2017, 1, 13, 11, 15, 0, 0, time.UTC)},
+		{"5,40 * * * *", time.Date(2017, 1, 13, 11, 40, 0, 0, time.UTC)},
+		{"0 8-18/2 * * *", time.Date(2017, 1, 13, 12, 0, 0, 0, time.UTC)},
+		{"30 2 * * 1-5", time.Date(2017, 1, 16, 2, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2017, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,20 * 0", time.Date(2017, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2017, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, c := range cases {
+		cron, err := ParseCron(c.spec)
+		if err != nil {
+			t.Error(c.spec, err)
+			continue
+		}
+		if next := cron.Next(from); !next.Equal(c.next) {
+			t.Error(c.spec, "expected", c.next, "got", next)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	specs := []string{"* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"}
+	for _, spec := range specs {
+		if _, err := ParseCron(spec); err == nil {
+			t.Error("expected error for", spec)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package tsq
+
+import (
+	"sync"
+	"time"
+)
+
+// Event tells that the status, result or progress of a job changed.
+type Event struct {
+	Type     string      `json:"type"`
+	Job      string      `json:"job"`
+	Status   string      `json:"status,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+	Progress *Progress   `json:"progress,omitempty"`
+	Time     time.Time   `json:"time"`
+}
+
+const (
+	EVENT_STATUS   = "status"
+	EVENT_RESULT   = "result"
+	EVENT_PROGRESS = "progress"
+)
+
+// pollInterval is how often subscribers that wait for a job to finish read it
+// again, as the event that tells it finished may have been dropped.
+var pollInterval = time.Second
+
+// eventBus hands events to subscribers. Subscribers that do not keep up lose
+// events rather than block the queue.
+type eventBus struct {
+	mutex       sync.Mutex
+	subscribers map[chan Event]string
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[chan Event]string)}
+}
+
+func (b *eventBus) subscribe(uuid string) chan Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	events := make(chan Event, 64)
+	b.subscribers[events] = uuid
+	return events
+}
+
+func (b *eventBus) unsubscribe(events chan Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.subscribers, events)
+}
+
+func (b *eventBus) publish(event Event) {
+	event.Time = time.Now()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for events, uuid := range b.subscribers {
+		if uuid != "" && uuid != event.Job {
+			continue
+		}
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the events of the job with the given uuid, or of all jobs
+// when uuid is empty. The returned function ends the subscription.
+func (q *TaskQueue) Subscribe(uuid string) (<-chan Event, func()) {
+	events := q.events.subscribe(uuid)
+	return events, func() {
+		q.events.unsubscribe(events)
+	}
+}
+
+// eventStore publishes an event for every change of the status, result or
+// progress of a job that goes through it.
+type eventStore struct {
+	JobStore
+	events *eventBus
+}
+
+func (s *eventStore) Store(job *Job) error {
+	err := s.JobStore.Store(job)
+	if err == nil {
+		s.events.publish(Event{Type: EVENT_STATUS, Job: job.UUID, Status: job.Status})
+	}
+	return err
+}
+
+func (s *eventStore) StoreJobs(jobs []*Job) error {
+	err := s.JobStore.StoreJobs(jobs)
+	if err == nil {
+		for _, job := range jobs {
+			s.events.publish(Event{Type: EVENT_STATUS, Job: job.UUID, Status: job.Status})
+		}
+	}
+	return err
+}
+
+func (s *eventStore) StoreUnique(job *Job, until time.Time) (*Job, error) {
+	stored, err := s.JobStore.StoreUnique(job, until)
+	if err == nil && stored == job {
+		s.events.publish(Event{Type: EVENT_STATUS, Job: job.UUID, Status: job.Status})
+	}
+	return stored, err
+}
+
+func (s *eventStore) StoreBatch(batch *Batch) error {
+	err := s.JobStore.StoreBatch(batch)
+	if err == nil {
+		for _, job := range batch.Jobs {
+			s.events.publish(Event{Type: EVENT_STATUS, Job: job.UUID, Status: job.Status})
+		}
+	}
+	return err
+}
+
+func (s *eventStore) SetStatus(uuid string, status string, updated time.Time) error {
+	err := s.JobStore.SetStatus(uuid, status, updated)
+	if err == nil {
+		s.events.publish(Event{Type: EVENT_STATUS, Job: uuid, Status: status})
+	}
+	return err
+}
+
+func (s *eventStore) SetResult(uuid string, result interface{}) error {
+	err := s.JobStore.SetResult(uuid, result)
+	if err == nil {
+		s.events.publish(Event{Type: EVENT_RESULT, Job: uuid, Result: result})
+	}
+	return err
+}
+
+func (s *eventStore) SetProgress(uuid string, progress Progress) error {
+	err := s.JobStore.SetProgress(uuid, progress)
+	if err == nil {
+		s.events.publish(Event{Type: EVENT_PROGRESS, Job: uuid, Progress: &progress})
+	}
+	return err
+}
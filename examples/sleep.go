@@ -1,8 +1,8 @@
 package main
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"log"
 	"net/http"
 	"time"
@@ -10,25 +10,23 @@ import (
 	"github.com/jhoekx/tsq"
 )
 
-type SleepTask struct{}
+type SleepArguments struct {
+	Duration float64 `json:"duration"`
+}
 
-func (t *SleepTask) Run(arguments interface{}) (data interface{}, err error) {
-	args, ok := arguments.(map[string]interface{})
-	if !ok {
-		err = errors.New("arguments required")
-		return
-	}
-	duration, ok := args["duration"]
-	if !ok {
+func sleep(ctx context.Context, args SleepArguments) (slept string, err error) {
+	if args.Duration <= 0 {
 		err = errors.New("duration argument required")
 		return
 	}
-	length, ok := duration.(float64)
-	if !ok {
-		err = errors.New("duration float64 argument required, was " + fmt.Sprintf("%T", duration))
+	duration := time.Duration(args.Duration * float64(time.Second))
+	select {
+	case <-time.After(duration):
+	case <-ctx.Done():
+		err = ctx.Err()
 		return
 	}
-	time.Sleep(time.Duration(length) * time.Second)
+	slept = duration.String()
 	return
 }
 
@@ -42,7 +40,7 @@ func logRequests(next http.Handler) http.Handler {
 func main() {
 	q := tsq.New()
 
-	q.Define("sleep", &SleepTask{})
+	tsq.DefineTyped(q, "sleep", sleep)
 
 	cmd := tsq.CommandTask{"sleep", []string{"5"}}
 	q.Define("sleep-5", &cmd)
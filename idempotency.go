@@ -0,0 +1,54 @@
+package tsq
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// IdempotencyKey records which job was created by a request with an
+// Idempotency-Key header.
+type IdempotencyKey struct {
+	Key         string
+	Fingerprint string
+	Job         string
+	Created     time.Time
+}
+
+var errIdempotencyConflict = errors.New("Idempotency-Key was used for a different request")
+
+// submitOnce submits a job unless an earlier request with the same key did,
+// in which case the job of that request is returned. Reusing a key for other
+// arguments fails with HTTP 422. Keys older than the retention are deleted on
+// the way. When the key cannot be stored, the job is still returned, as it
+// has been queued already.
+func (s *server) submitOnce(key string, name string, arguments interface{}, submit func() (*Job, error)) (*Job, error) {
+	if key == "" {
+		return submit()
+	}
+	fingerprint := hashSubmission(name, arguments)
+
+	s.idempotencyMutex.Lock()
+	defer s.idempotencyMutex.Unlock()
+	err := s.taskQueue.jobStore.DeleteIdempotencyKeys(time.Now().Add(-s.taskQueue.retention))
+	if err != nil {
+		log.Println("Failed to delete expired idempotency keys: " + err.Error())
+	}
+	stored, err := s.taskQueue.jobStore.GetIdempotencyKey(key)
+	if err == nil && time.Since(stored.Created) < s.taskQueue.retention {
+		if stored.Fingerprint != fingerprint {
+			return nil, &httpError{422, errIdempotencyConflict}
+		}
+		return s.taskQueue.GetJob(stored.Job)
+	}
+
+	job, err := submit()
+	if err != nil {
+		return nil, err
+	}
+	err = s.taskQueue.jobStore.StoreIdempotencyKey(&IdempotencyKey{key, fingerprint, job.UUID, time.Now()})
+	if err != nil {
+		log.Println("Failed to store idempotency key " + key + " of job " + job.UUID + ": " + err.Error())
+	}
+	return job, nil
+}
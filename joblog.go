@@ -0,0 +1,58 @@
+package tsq
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// LogLine is a line that the runner of a job logged. Offset numbers the lines
+// of a job from zero.
+type LogLine struct {
+	Offset  int       `json:"offset"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// JobLogger stores log lines of a running job.
+type JobLogger struct {
+	queue *TaskQueue
+	uuid  string
+}
+
+type loggerKey struct{}
+
+// Logger returns the logger of the job that runs with ctx. Outside of a job
+// it returns nil, which discards all lines.
+func Logger(ctx context.Context) *JobLogger {
+	l, _ := ctx.Value(loggerKey{}).(*JobLogger)
+	return l
+}
+
+func (l *JobLogger) Printf(format string, v ...interface{}) {
+	l.log(fmt.Sprintf(format, v...))
+}
+
+func (l *JobLogger) Println(v ...interface{}) {
+	message := fmt.Sprintln(v...)
+	l.log(message[:len(message)-1])
+}
+
+// log stores a line, unless the job is no longer running.
+func (l *JobLogger) log(message string) {
+	if l == nil {
+		return
+	}
+	if !l.queue.isRunning(l.uuid) {
+		return
+	}
+	l.queue.jobStore.AppendLog(l.uuid, &LogLine{Time: time.Now(), Message: message})
+}
+
+// GetLogs returns at most limit log lines of a job, starting at offset.
+func (q *TaskQueue) GetLogs(uuid string, offset int, limit int) ([]LogLine, error) {
+	if _, err := q.jobStore.GetJob(uuid); err != nil {
+		return nil, err
+	}
+	return q.jobStore.GetLogs(uuid, offset, limit)
+}
@@ -2,18 +2,34 @@ package tsq
 
 import (
 	"errors"
+	"sort"
 	"sync"
 	"time"
 )
 
 type MemoryStore struct {
-	jobMutex sync.Mutex
-	jobs     []*Job
+	jobMutex  sync.RWMutex
+	jobs      []*Job
+	schedules map[string]Schedule
+	batches   map[string]Batch
+	unique    map[string]uniqueClaim
+	keys      map[string]IdempotencyKey
+	logs      map[string][]LogLine
+}
+
+type uniqueClaim struct {
+	uuid  string
+	until time.Time
 }
 
 func NewMemoryStore() JobStore {
 	store := &MemoryStore{}
 	store.jobs = make([]*Job, 0, 10)
+	store.schedules = make(map[string]Schedule)
+	store.batches = make(map[string]Batch)
+	store.unique = make(map[string]uniqueClaim)
+	store.keys = make(map[string]IdempotencyKey)
+	store.logs = make(map[string][]LogLine)
 	return store
 }
 
@@ -24,18 +40,86 @@ func (s *MemoryStore) Start() (err error) {
 func (s *MemoryStore) Stop() {
 }
 
+// Store keeps a copy of job. Jobs are also handed out as copies, so that
+// callers can read them while workers change their status.
 func (s *MemoryStore) Store(job *Job) error {
 	s.jobMutex.Lock()
-	s.jobs = append(s.jobs, job)
+	s.jobs = append(s.jobs, copyJob(job))
 	s.jobMutex.Unlock()
 	return nil
 }
 
+func (s *MemoryStore) StoreJobs(jobs []*Job) error {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	for _, job := range jobs {
+		s.jobs = append(s.jobs, copyJob(job))
+	}
+	return nil
+}
+
+func (s *MemoryStore) StoreUnique(job *Job, until time.Time) (*Job, error) {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	if claim, ok := s.unique[job.UniqueKey]; ok {
+		existing, err := s.findJob(claim.uuid)
+		if err == nil && (claim.until.After(time.Now()) || !existing.HasFinished()) {
+			return copyJob(existing), nil
+		}
+	}
+	s.unique[job.UniqueKey] = uniqueClaim{job.UUID, until}
+	s.jobs = append(s.jobs, copyJob(job))
+	return job, nil
+}
+
 func (s *MemoryStore) GetJobs() ([]*Job, error) {
-	return s.jobs, nil
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+	jobs := make([]*Job, len(s.jobs))
+	for i, job := range s.jobs {
+		jobs[i] = copyJob(job)
+	}
+	return jobs, nil
+}
+
+func (s *MemoryStore) GetJobsByStatus(statuses ...string) ([]*Job, error) {
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+	jobs := make([]*Job, 0)
+	for _, job := range s.jobs {
+		for _, status := range statuses {
+			if job.Status == status {
+				jobs = append(jobs, copyJob(job))
+				break
+			}
+		}
+	}
+	return jobs, nil
+}
+
+func (s *MemoryStore) GetJobsByWorkflow(workflow string) ([]*Job, error) {
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+	jobs := make([]*Job, 0)
+	for _, job := range s.jobs {
+		if job.Workflow == workflow {
+			jobs = append(jobs, copyJob(job))
+		}
+	}
+	return jobs, nil
 }
 
 func (s *MemoryStore) GetJob(uuid string) (job *Job, err error) {
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+	job, err = s.findJob(uuid)
+	if err != nil {
+		return
+	}
+	return copyJob(job), nil
+}
+
+func (s *MemoryStore) findJob(uuid string) (job *Job, err error) {
 	for _, job := range s.jobs {
 		if job.UUID == uuid {
 			return job, err
@@ -45,8 +129,21 @@ func (s *MemoryStore) GetJob(uuid string) (job *Job, err error) {
 	return
 }
 
+func copyJob(job *Job) *Job {
+	c := *job
+	c.Errors = append([]string(nil), job.Errors...)
+	c.Dependencies = append([]string(nil), job.Dependencies...)
+	if job.Progress != nil {
+		progress := *job.Progress
+		c.Progress = &progress
+	}
+	return &c
+}
+
 func (s *MemoryStore) SetStatus(uuid string, status string, updated time.Time) (err error) {
-	job, err := s.GetJob(uuid)
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	job, err := s.findJob(uuid)
 	if err != nil {
 		return
 	}
@@ -55,11 +152,166 @@ func (s *MemoryStore) SetStatus(uuid string, status string, updated time.Time) (
 	return
 }
 
+func (s *MemoryStore) SetProgress(uuid string, progress Progress) (err error) {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	job, err := s.findJob(uuid)
+	if err != nil {
+		return
+	}
+	job.Progress = &progress
+	return
+}
+
+func (s *MemoryStore) AppendLog(uuid string, line *LogLine) error {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	line.Offset = len(s.logs[uuid])
+	s.logs[uuid] = append(s.logs[uuid], *line)
+	return nil
+}
+
+func (s *MemoryStore) GetLogs(uuid string, offset int, limit int) ([]LogLine, error) {
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+	logs := s.logs[uuid]
+	if offset >= len(logs) {
+		return []LogLine{}, nil
+	}
+	end := len(logs)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	lines := make([]LogLine, end-offset)
+	copy(lines, logs[offset:end])
+	return lines, nil
+}
+
 func (s *MemoryStore) SetResult(uuid string, result interface{}) (err error) {
-	job, err := s.GetJob(uuid)
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	job, err := s.findJob(uuid)
 	if err != nil {
 		return
 	}
 	job.Result = result
 	return
 }
+
+func (s *MemoryStore) SetAttempts(uuid string, attempts int, errors []string) (err error) {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	job, err := s.findJob(uuid)
+	if err != nil {
+		return
+	}
+	job.Attempts = attempts
+	job.Errors = append([]string(nil), errors...)
+	return
+}
+
+func (s *MemoryStore) StoreSchedule(schedule *Schedule) error {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	s.schedules[schedule.Name] = *schedule
+	return nil
+}
+
+func (s *MemoryStore) GetSchedules() ([]*Schedule, error) {
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+	schedules := make([]*Schedule, 0, len(s.schedules))
+	for _, schedule := range s.schedules {
+		stored := schedule
+		schedules = append(schedules, &stored)
+	}
+	return schedules, nil
+}
+
+func (s *MemoryStore) StoreBatch(batch *Batch) error {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	s.batches[batch.ID] = Batch{ID: batch.ID, OnComplete: batch.OnComplete, CallbackJob: batch.CallbackJob, Created: batch.Created}
+	for _, job := range batch.Jobs {
+		s.jobs = append(s.jobs, copyJob(job))
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetBatch(id string) (*Batch, error) {
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+	batch, ok := s.batches[id]
+	if !ok {
+		return nil, errors.New("Batch " + id + " not found")
+	}
+	return &batch, nil
+}
+
+func (s *MemoryStore) GetJobsByBatch(id string) ([]*Job, error) {
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+	jobs := make([]*Job, 0)
+	for _, job := range s.jobs {
+		if job.Batch == id {
+			jobs = append(jobs, copyJob(job))
+		}
+	}
+	return jobs, nil
+}
+
+func (s *MemoryStore) GetUnsettledBatches() ([]*Batch, error) {
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+	batches := make([]*Batch, 0)
+	for _, batch := range s.batches {
+		if batch.OnComplete != "" && batch.CallbackJob == "" {
+			stored := batch
+			batches = append(batches, &stored)
+		}
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].Created.Before(batches[j].Created)
+	})
+	return batches, nil
+}
+
+func (s *MemoryStore) SetBatchCallback(id string, uuid string) error {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	batch, ok := s.batches[id]
+	if !ok {
+		return errors.New("Batch " + id + " not found")
+	}
+	batch.CallbackJob = uuid
+	s.batches[id] = batch
+	return nil
+}
+
+func (s *MemoryStore) StoreIdempotencyKey(key *IdempotencyKey) error {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	s.keys[key.Key] = *key
+	return nil
+}
+
+func (s *MemoryStore) DeleteIdempotencyKeys(before time.Time) error {
+	s.jobMutex.Lock()
+	defer s.jobMutex.Unlock()
+	for key, stored := range s.keys {
+		if stored.Created.Before(before) {
+			delete(s.keys, key)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetIdempotencyKey(key string) (*IdempotencyKey, error) {
+	s.jobMutex.RLock()
+	defer s.jobMutex.RUnlock()
+	stored, ok := s.keys[key]
+	if !ok {
+		return nil, errors.New("Idempotency key " + key + " not found")
+	}
+	return &stored, nil
+}
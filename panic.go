@@ -0,0 +1,23 @@
+package tsq
+
+import (
+	"fmt"
+)
+
+// PanicError is the error of a job whose runner panicked.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Panics returns the number of jobs whose runner panicked since the queue
+// was created.
+func (q *TaskQueue) Panics() int {
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
+	return q.panics
+}
@@ -0,0 +1,53 @@
+package tsq
+
+import (
+	"errors"
+)
+
+// Pause stops the queue from starting jobs until Resume is called. Jobs are
+// still accepted and stored, and running jobs are not interrupted.
+func (q *TaskQueue) Pause() {
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
+	q.paused = true
+}
+
+// Resume lets a paused queue start jobs again. A queue that is shutting down
+// stays paused.
+func (q *TaskQueue) Resume() {
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
+	if q.isClosed() {
+		return
+	}
+	q.paused = false
+	q.wake()
+}
+
+func (q *TaskQueue) IsPaused() bool {
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
+	return q.paused
+}
+
+// PauseTask stops the queue from starting jobs of a single task.
+func (q *TaskQueue) PauseTask(name string) error {
+	return q.setTaskPaused(name, true)
+}
+
+// ResumeTask lets the queue start jobs of a paused task again.
+func (q *TaskQueue) ResumeTask(name string) error {
+	return q.setTaskPaused(name, false)
+}
+
+func (q *TaskQueue) setTaskPaused(name string, paused bool) error {
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
+	t, ok := q.tasks[name]
+	if !ok {
+		return errors.New("Unknown task: " + name)
+	}
+	t.paused = paused
+	q.wake()
+	return nil
+}
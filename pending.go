@@ -0,0 +1,136 @@
+package tsq
+
+import (
+	"container/heap"
+	"time"
+)
+
+// pendingJob is a job that is due and waiting for a worker. Jobs run in order
+// of their rank: the time they became due, moved forward by the aging
+// interval for every level of priority. A job with a low priority therefore
+// overtakes newer jobs with a higher priority once it has waited long enough.
+type pendingJob struct {
+	job  *Job
+	rank time.Time
+	seq  uint64
+	slot bool
+}
+
+type pendingJobs []*pendingJob
+
+func (p pendingJobs) Len() int {
+	return len(p)
+}
+
+func (p pendingJobs) Less(i, j int) bool {
+	if p[i].rank.Equal(p[j].rank) {
+		return p[i].seq < p[j].seq
+	}
+	return p[i].rank.Before(p[j].rank)
+}
+
+func (p pendingJobs) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
+
+func (p *pendingJobs) Push(x interface{}) {
+	*p = append(*p, x.(*pendingJob))
+}
+
+func (p *pendingJobs) Pop() interface{} {
+	old := *p
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*p = old[:n-1]
+	return item
+}
+
+// enqueue makes a due job available to the dispatcher. slot tells whether
+// the job holds one of the submission slots that bound the queue length. The
+// caller must hold q.jobMutex.
+func (q *TaskQueue) enqueue(job *Job, due time.Time, slot bool) {
+	q.sequence++
+	heap.Push(&q.pending, &pendingJob{
+		job:  job,
+		rank: due.Add(-time.Duration(job.Priority) * q.aging),
+		seq:  q.sequence,
+		slot: slot,
+	})
+	q.wake()
+}
+
+// wake tells the dispatcher to look for jobs to start.
+func (q *TaskQueue) wake() {
+	select {
+	case q.wakeup <- true:
+	default:
+	}
+}
+
+// dequeue removes the best ranked pending job of a task that is not paused
+// and has not reached its concurrency or rate limit. When jobs are held back by a rate limit, the
+// dispatcher is woken up once the next token is available. The caller must
+// hold q.jobMutex.
+func (q *TaskQueue) dequeue() *Job {
+	now := time.Now()
+	var skipped []*pendingJob
+	var limitedUntil time.Time
+	defer func() {
+		for _, p := range skipped {
+			heap.Push(&q.pending, p)
+		}
+		if !limitedUntil.IsZero() {
+			q.wakeAt(limitedUntil)
+		}
+	}()
+	if q.paused || q.isClosed() || !q.hasIdleWorkers() {
+		return nil
+	}
+	for q.pending.Len() > 0 {
+		p := heap.Pop(&q.pending).(*pendingJob)
+		t := q.tasks[p.job.Name]
+		if t.paused || !t.hasCapacity() {
+			skipped = append(skipped, p)
+			continue
+		}
+		if t.limit != nil && !t.limit.take(now) {
+			if next := t.limit.next(now); limitedUntil.IsZero() || next.Before(limitedUntil) {
+				limitedUntil = next
+			}
+			skipped = append(skipped, p)
+			continue
+		}
+		q.release(p)
+		return p.job
+	}
+	return nil
+}
+
+// unqueue removes a pending job and reports whether it was found. The caller
+// must hold q.jobMutex.
+func (q *TaskQueue) unqueue(uuid string) bool {
+	for i, p := range q.pending {
+		if p.job.UUID == uuid {
+			heap.Remove(&q.pending, i)
+			q.release(p)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *TaskQueue) hasIdleWorkers() bool {
+	for _, l := range q.lanes {
+		if l.running < l.workers {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *TaskQueue) release(p *pendingJob) {
+	if p.slot {
+		<-q.tasks[p.job.Name].lane.slots
+	}
+}
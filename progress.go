@@ -0,0 +1,41 @@
+package tsq
+
+import (
+	"context"
+	"time"
+)
+
+// Progress is the latest progress that the runner of a job reported.
+type Progress struct {
+	Percent float64   `json:"percent"`
+	Step    string    `json:"step,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// Reporter records the progress of a running job.
+type Reporter struct {
+	queue *TaskQueue
+	uuid  string
+}
+
+type reporterKey struct{}
+
+// ProgressReporter returns the reporter of the job that runs with ctx. Outside
+// of a job it returns nil, on which Report does nothing.
+func ProgressReporter(ctx context.Context) *Reporter {
+	r, _ := ctx.Value(reporterKey{}).(*Reporter)
+	return r
+}
+
+// Report stores the progress of the job. Reports of a job that is no longer
+// running are ignored.
+func (r *Reporter) Report(percent float64, step string, message string) error {
+	if r == nil {
+		return nil
+	}
+	if !r.queue.isRunning(r.uuid) {
+		return nil
+	}
+	return r.queue.jobStore.SetProgress(r.uuid, Progress{percent, step, message, time.Now()})
+}
@@ -0,0 +1,74 @@
+package tsq
+
+import (
+	"sort"
+)
+
+// DefaultQueue is the queue of tasks that are not assigned to another queue.
+// Its size is set by Config.Workers and Config.QueueLength.
+const DefaultQueue = "default"
+
+// QueueConfig sets the size of a named queue. Zero values fall back to
+// DefaultConfig.
+type QueueConfig struct {
+	QueueLength int
+	Workers     int
+}
+
+// lane is a named queue: a group of tasks with its own workers and queue
+// length. All lanes share the dispatcher and the job store.
+type lane struct {
+	name    string
+	workers int
+	running int
+	slots   chan bool
+}
+
+func newLane(name string, config QueueConfig) *lane {
+	c := Config{QueueLength: config.QueueLength, Workers: config.Workers}
+	return &lane{
+		name:    name,
+		workers: c.getWorkers(),
+		slots:   make(chan bool, c.getQueueLength()),
+	}
+}
+
+// InQueue assigns a task to one of the queues of Config.Queues.
+func InQueue(name string) TaskOption {
+	return func(t *task) {
+		t.queue = name
+	}
+}
+
+// QueueInfo describes a queue and how busy it is.
+type QueueInfo struct {
+	Name        string `json:"name"`
+	Workers     int    `json:"workers"`
+	QueueLength int    `json:"queueLength"`
+	Running     int    `json:"running"`
+	Pending     int    `json:"pending"`
+}
+
+// GetQueues returns the queues, sorted by name.
+func (q *TaskQueue) GetQueues() []QueueInfo {
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
+	pending := make(map[string]int)
+	for _, p := range q.pending {
+		pending[q.tasks[p.job.Name].lane.name]++
+	}
+	queues := make([]QueueInfo, 0, len(q.lanes))
+	for _, l := range q.lanes {
+		queues = append(queues, QueueInfo{
+			Name:        l.name,
+			Workers:     l.workers,
+			QueueLength: cap(l.slots),
+			Running:     l.running,
+			Pending:     pending[l.name],
+		})
+	}
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Name < queues[j].Name
+	})
+	return queues
+}
@@ -0,0 +1,107 @@
+package tsq
+
+import (
+	"sort"
+	"time"
+)
+
+// RateLimit allows a task to start one job per interval, with bursts of up to
+// burst jobs. Jobs over the limit stay pending until a token is available.
+func RateLimit(interval time.Duration, burst int) TaskOption {
+	return func(t *task) {
+		if burst < 1 {
+			burst = 1
+		}
+		t.limit = &tokenBucket{interval: interval, burst: burst, tokens: float64(burst)}
+	}
+}
+
+type tokenBucket struct {
+	interval time.Duration
+	burst    int
+	tokens   float64
+	last     time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() && b.interval > 0 {
+		b.tokens += float64(now.Sub(b.last)) / float64(b.interval)
+	}
+	if b.tokens > float64(b.burst) || b.interval <= 0 {
+		b.tokens = float64(b.burst)
+	}
+	b.last = now
+}
+
+// take removes a token from the bucket if one is available.
+func (b *tokenBucket) take(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// next returns when the next token will be available.
+func (b *tokenBucket) next(now time.Time) time.Time {
+	b.refill(now)
+	return now.Add(time.Duration((1 - b.tokens) * float64(b.interval)))
+}
+
+// TaskInfo describes a defined task and the current state of its limits.
+type TaskInfo struct {
+	Name           string         `json:"name"`
+	Queue          string         `json:"queue"`
+	Paused         bool           `json:"paused"`
+	Running        int            `json:"running"`
+	Pending        int            `json:"pending"`
+	MaxConcurrency int            `json:"maxConcurrency,omitempty"`
+	RateLimit      *RateLimitInfo `json:"rateLimit,omitempty"`
+}
+
+type RateLimitInfo struct {
+	IntervalSeconds float64 `json:"intervalSeconds"`
+	Burst           int     `json:"burst"`
+	Tokens          float64 `json:"tokens"`
+}
+
+// GetTasks returns the defined tasks, sorted by name.
+func (q *TaskQueue) GetTasks() []TaskInfo {
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
+	pending := make(map[string]int)
+	for _, p := range q.pending {
+		pending[p.job.Name]++
+	}
+	now := time.Now()
+	tasks := make([]TaskInfo, 0, len(q.tasks))
+	for name, t := range q.tasks {
+		info := TaskInfo{
+			Name:           name,
+			Queue:          t.lane.name,
+			Paused:         t.paused,
+			Running:        t.running,
+			Pending:        pending[name],
+			MaxConcurrency: t.concurrency,
+		}
+		if t.limit != nil {
+			t.limit.refill(now)
+			info.RateLimit = &RateLimitInfo{t.limit.interval.Seconds(), t.limit.burst, t.limit.tokens}
+		}
+		tasks = append(tasks, info)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Name < tasks[j].Name
+	})
+	return tasks
+}
+
+// wakeAt wakes up the dispatcher at a later time, replacing an earlier
+// request. The caller must hold q.jobMutex.
+func (q *TaskQueue) wakeAt(at time.Time) {
+	if q.limitTimer != nil {
+		q.limitTimer.Stop()
+	}
+	q.limitTimer = time.AfterFunc(time.Until(at), q.wake)
+}
@@ -0,0 +1,54 @@
+package tsq
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy describes how often and how fast a failed job is retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of runs, including the first one.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts, when positive.
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after every attempt. Defaults to 2.
+	Multiplier float64
+	// Jitter randomly changes every delay by up to this fraction of it.
+	Jitter float64
+	// Retryable decides whether an error is worth retrying. All errors are
+	// retried when it is nil.
+	Retryable func(error) bool
+}
+
+// Retry makes the queue retry failed jobs of a task according to policy.
+func Retry(policy RetryPolicy) TaskOption {
+	return func(t *task) {
+		t.retry = &policy
+	}
+}
+
+func (p *RetryPolicy) shouldRetry(attempts int, err error) bool {
+	if attempts >= p.MaxAttempts {
+		return false
+	}
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+func (p *RetryPolicy) backoff(attempts int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempts-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	backoff += backoff * p.Jitter * (2*rand.Float64() - 1)
+	if backoff < 0 {
+		backoff = 0
+	}
+	return time.Duration(backoff)
+}
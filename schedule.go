@@ -0,0 +1,159 @@
+package tsq
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+// Schedule submits a task with fixed arguments whenever its cron expression
+// matches.
+type Schedule struct {
+	Name      string      `json:"name"`
+	Task      string      `json:"task"`
+	Spec      string      `json:"spec"`
+	Arguments interface{} `json:"arguments"`
+	LastRun   time.Time   `json:"lastRun"`
+	LastJob   string      `json:"lastJob"`
+	NextRun   time.Time   `json:"nextRun"`
+
+	cron *CronSchedule
+}
+
+// Schedule submits task with arguments at the times matched by the cron
+// expression spec. A run is skipped while the job of the previous run has not
+// finished yet. The last and next run of a schedule are kept in the job store
+// under its name, so they survive a restart of the queue.
+func (q *TaskQueue) Schedule(name string, task string, spec string, arguments interface{}) (err error) {
+	cron, err := ParseCron(spec)
+	if err != nil {
+		return
+	}
+	if _, ok := q.tasks[task]; !ok {
+		return errors.New("Unknown task: " + task)
+	}
+
+	q.scheduleMutex.Lock()
+	defer q.scheduleMutex.Unlock()
+	if _, ok := q.schedules[name]; ok {
+		return errors.New("Schedule " + name + " already exists")
+	}
+	schedule := &Schedule{Name: name, Task: task, Spec: spec, Arguments: arguments, cron: cron}
+	if q.started {
+		err = q.startSchedule(schedule)
+		if err != nil {
+			return
+		}
+	}
+	q.schedules[name] = schedule
+	return
+}
+
+// GetSchedules returns a snapshot of all schedules, sorted by name.
+func (q *TaskQueue) GetSchedules() []*Schedule {
+	q.scheduleMutex.Lock()
+	defer q.scheduleMutex.Unlock()
+	schedules := make([]*Schedule, 0, len(q.schedules))
+	for _, schedule := range q.schedules {
+		snapshot := *schedule
+		schedules = append(schedules, &snapshot)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Name < schedules[j].Name
+	})
+	return schedules
+}
+
+// startSchedules starts all schedules that were defined before the queue was
+// started. It must be called after the job store was started.
+func (q *TaskQueue) startSchedules() (err error) {
+	q.scheduleMutex.Lock()
+	defer q.scheduleMutex.Unlock()
+	q.started = true
+	for _, schedule := range q.schedules {
+		err = q.startSchedule(schedule)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// startSchedule restores the state of a schedule from the job store and
+// starts waiting for its next run. The caller must hold q.scheduleMutex.
+func (q *TaskQueue) startSchedule(schedule *Schedule) (err error) {
+	stored, err := q.jobStore.GetSchedules()
+	if err != nil {
+		return
+	}
+	for _, s := range stored {
+		if s.Name == schedule.Name && s.Spec == schedule.Spec {
+			schedule.LastRun = s.LastRun
+			schedule.LastJob = s.LastJob
+			schedule.NextRun = s.NextRun
+		}
+	}
+	if schedule.NextRun.IsZero() {
+		schedule.NextRun = schedule.cron.Next(time.Now())
+	}
+	err = q.jobStore.StoreSchedule(schedule)
+	if err != nil {
+		return
+	}
+	go q.runSchedule(schedule)
+	return
+}
+
+func (q *TaskQueue) runSchedule(schedule *Schedule) {
+	for {
+		q.scheduleMutex.Lock()
+		next := schedule.NextRun
+		q.scheduleMutex.Unlock()
+		if next.IsZero() {
+			log.Println("Schedule " + schedule.Name + " will not run again")
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			q.trigger(schedule)
+		case <-q.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (q *TaskQueue) trigger(schedule *Schedule) {
+	q.scheduleMutex.Lock()
+	defer q.scheduleMutex.Unlock()
+
+	now := time.Now()
+	schedule.NextRun = schedule.cron.Next(now)
+	if schedule.LastJob != "" {
+		job, err := q.jobStore.GetJob(schedule.LastJob)
+		if err == nil && !job.HasFinished() {
+			log.Println("Skipping schedule " + schedule.Name + ": job " + job.UUID + " is " + job.Status)
+			q.storeSchedule(schedule)
+			return
+		}
+	}
+
+	job, err := q.Submit(schedule.Task, schedule.Arguments)
+	if err != nil {
+		log.Println("Schedule " + schedule.Name + " failed to submit: " + err.Error())
+	} else {
+		schedule.LastRun = now
+		schedule.LastJob = job.UUID
+	}
+	q.storeSchedule(schedule)
+}
+
+func (q *TaskQueue) storeSchedule(schedule *Schedule) {
+	err := q.jobStore.StoreSchedule(schedule)
+	if err != nil {
+		log.Println("Failed to store schedule " + schedule.Name + ": " + err.Error())
+	}
+}
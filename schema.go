@@ -0,0 +1,259 @@
+package tsq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema is the subset of JSON Schema that tasks use to describe their
+// arguments: types, object properties, required properties, array items,
+// enums and bounds on numbers, strings and arrays.
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+// ParseSchema reads a JSON Schema. Keywords that Schema does not support are
+// ignored.
+func ParseSchema(data []byte) (*Schema, error) {
+	schema := &Schema{}
+	err := json.Unmarshal(data, schema)
+	if err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// ArgumentSchema makes Submit reject jobs of a task whose arguments do not
+// match schema.
+func ArgumentSchema(schema *Schema) TaskOption {
+	return func(t *task) {
+		t.schema = schema
+	}
+}
+
+// SchemaFor derives a schema from the Go type of v, following the rules of
+// encoding/json. Struct fields are required unless they are pointers or
+// tagged omitempty. Types that decode themselves through json.Unmarshaler,
+// and structs nested in themselves, accept any value.
+func SchemaFor(v interface{}) *Schema {
+	return schemaForType(reflect.TypeOf(v), make(map[reflect.Type]bool))
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// schemaForType describes t. The structs that are being described are kept
+// in seen, so that recursive types end.
+func schemaForType(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return &Schema{Type: "string"}
+	}
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string"}
+		}
+		return &Schema{Type: "array", Items: schemaForType(t.Elem(), seen)}
+	case reflect.Map:
+		return &Schema{Type: "object"}
+	case reflect.Struct:
+		if seen[t] {
+			return &Schema{}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		addFields(schema, t, seen)
+		sort.Strings(schema.Required)
+		return schema
+	}
+	return &Schema{}
+}
+
+func addFields(schema *Schema, t reflect.Type, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addFields(schema, field.Type, seen)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = schemaForType(field.Type, seen)
+		if field.Type.Kind() != reflect.Ptr && !strings.Contains(","+options+",", ",omitempty,") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// Validate checks a value as decoded by encoding/json against the schema and
+// returns a description of every mismatch.
+func (s *Schema) Validate(value interface{}) []string {
+	return s.validate("$", value, nil)
+}
+
+func (s *Schema) validate(path string, value interface{}, errs []string) []string {
+	if s.Type != "" && !hasType(value, s.Type) {
+		return append(errs, fmt.Sprintf("%s: expected %s, got %s", path, s.Type, typeOf(value)))
+	}
+	if len(s.Enum) > 0 && !inEnum(value, s.Enum) {
+		errs = append(errs, fmt.Sprintf("%s: must be one of %v", path, s.Enum))
+	}
+	switch v := value.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			errs = append(errs, fmt.Sprintf("%s: must be at least %v", path, *s.Minimum))
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			errs = append(errs, fmt.Sprintf("%s: must be at most %v", path, *s.Maximum))
+		}
+	case string:
+		length := len([]rune(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			errs = append(errs, fmt.Sprintf("%s: must be at least %d characters long", path, *s.MinLength))
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			errs = append(errs, fmt.Sprintf("%s: must be at most %d characters long", path, *s.MaxLength))
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			errs = append(errs, fmt.Sprintf("%s: must have at least %d items", path, *s.MinItems))
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			errs = append(errs, fmt.Sprintf("%s: must have at most %d items", path, *s.MaxItems))
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				errs = s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				errs = append(errs, fmt.Sprintf("%s.%s: is required", path, name))
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if ok {
+				errs = property.validate(path+"."+name, v[name], errs)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				errs = append(errs, fmt.Sprintf("%s.%s: is not allowed", path, name))
+			}
+		}
+	}
+	return errs
+}
+
+func hasType(value interface{}, expected string) bool {
+	actual := typeOf(value)
+	return actual == expected || (expected == "number" && actual == "integer")
+}
+
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func inEnum(value interface{}, enum []interface{}) bool {
+	for _, allowed := range enum {
+		if reflect.DeepEqual(value, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateArguments checks the arguments of a new job against the schema of
+// its task.
+func (t *task) validateArguments(name string, arguments interface{}) error {
+	if t.schema != nil {
+		value, err := convertJSON(arguments)
+		if err != nil {
+			return &ValidationError{name, []string{err.Error()}}
+		}
+		if errs := t.schema.Validate(value); len(errs) > 0 {
+			return &ValidationError{name, errs}
+		}
+	}
+	if t.validate != nil {
+		return t.validate(arguments)
+	}
+	return nil
+}
+
+// GetSchema returns the argument schema of a task.
+func (q *TaskQueue) GetSchema(name string) (*Schema, error) {
+	t, ok := q.tasks[name]
+	if !ok {
+		return nil, errors.New("Unknown task: " + name)
+	}
+	if t.schema == nil {
+		return nil, errors.New("Task " + name + " has no argument schema")
+	}
+	return t.schema, nil
+}
@@ -0,0 +1,104 @@
+package tsq
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := ParseSchema([]byte(`{
+		"type": "object",
+		"required": ["name", "count"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"count": {"type": "integer", "minimum": 1, "maximum": 10},
+			"mode": {"enum": ["fast", "slow"]},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		value  string
+		errors []string
+	}{
+		{`{"name": "a", "count": 2, "mode": "fast", "tags": ["x"]}`, nil},
+		{`"a"`, []string{"$: expected object, got string"}},
+		{`{"name": "", "count": 1.5}`, []string{"$.count: expected integer, got number", "$.name: must be at least 1 characters long"}},
+		{`{"name": "a"}`, []string{"$.count: is required"}},
+		{`{"name": "a", "count": 11, "mode": "other"}`, []string{"$.count: must be at most 10", "$.mode: must be one of [fast slow]"}},
+		{`{"name": "a", "count": 1, "tags": ["x", 2, "z"], "extra": true}`, []string{"$.extra: is not allowed", "$.tags: must have at most 2 items", "$.tags[1]: expected string, got integer"}},
+	}
+	for _, c := range cases {
+		var value interface{}
+		if err := json.Unmarshal([]byte(c.value), &value); err != nil {
+			t.Fatal(err)
+		}
+		if errs := schema.Validate(value); !reflect.DeepEqual(errs, c.errors) {
+			t.Error(c.value, "expected", c.errors, "got", errs)
+		}
+	}
+}
+
+func TestSchemaFor(t *testing.T) {
+	type Options struct {
+		Verbose bool `json:"verbose"`
+	}
+	type Arguments struct {
+		Options
+		Host    string   `json:"host"`
+		Port    int      `json:"port,omitempty"`
+		Paths   []string `json:"paths"`
+		Timeout *float64 `json:"timeout"`
+		Ignored string   `json:"-"`
+		hidden  string
+	}
+	schema := SchemaFor(Arguments{})
+	if schema.Type != "object" || len(schema.Properties) != 5 {
+		t.Fatal("unexpected schema", schema.Type, schema.Properties)
+	}
+	if !reflect.DeepEqual(schema.Required, []string{"host", "paths", "verbose"}) {
+		t.Error("unexpected required properties", schema.Required)
+	}
+	if schema.Properties["port"].Type != "integer" || schema.Properties["paths"].Items.Type != "string" || schema.Properties["timeout"].Type != "number" {
+		t.Error("unexpected property types")
+	}
+}
+
+type Node struct {
+	Name     string `json:"name"`
+	Children []Node `json:"children"`
+}
+
+func TestSchemaForRecursiveTypes(t *testing.T) {
+	schema := SchemaFor(Node{})
+	children := schema.Properties["children"]
+	if children.Type != "array" || children.Items.Type != "" {
+		t.Error("unexpected schema for recursive type", children)
+	}
+	value := map[string]interface{}{
+		"name":     "root",
+		"children": []interface{}{map[string]interface{}{"name": "leaf", "children": []interface{}{}}},
+	}
+	if errs := schema.Validate(value); len(errs) > 0 {
+		t.Error(errs)
+	}
+}
+
+func TestSchemaForUnmarshalers(t *testing.T) {
+	type Arguments struct {
+		Raw     json.RawMessage `json:"raw"`
+		Created time.Time       `json:"created"`
+	}
+	schema := SchemaFor(Arguments{})
+	if schema.Properties["raw"].Type != "" || schema.Properties["created"].Type != "string" {
+		t.Error("unexpected property types", schema.Properties["raw"], schema.Properties["created"])
+	}
+	if errs := schema.Validate(map[string]interface{}{"raw": 1.0, "created": "2024-01-01T00:00:00Z"}); len(errs) > 0 {
+		t.Error(errs)
+	}
+}
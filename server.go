@@ -6,6 +6,7 @@ import (
 	"mime"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -14,6 +15,8 @@ import (
 type server struct {
 	taskQueue *TaskQueue
 	router    *mux.Router
+
+	idempotencyMutex sync.Mutex
 }
 
 func ServeQueue(baseURL string, q *TaskQueue) http.Handler {
@@ -28,15 +31,36 @@ func ServeQueue(baseURL string, q *TaskQueue) http.Handler {
 
 func (s *server) registerRoutes() {
 	s.router.HandleFunc("/", jsonResponse(s.listServices))
+	s.router.HandleFunc("/status/", jsonResponse(s.getStatus)).Name("status")
+	s.router.HandleFunc("/pause/", jsonResponse(s.pauseQueue)).Methods("POST")
+	s.router.HandleFunc("/resume/", jsonResponse(s.resumeQueue)).Methods("POST")
+	s.router.HandleFunc("/queues/", jsonResponse(s.listQueues)).Name("queues")
 	s.router.HandleFunc("/tasks/", jsonResponse(s.listDefinedTasks)).Name("tasks")
 	s.router.HandleFunc("/tasks/{name}/", jsonResponse(s.submitTask)).Methods("POST").Name("submitTask")
+	s.router.HandleFunc("/tasks/{name}/schema", jsonResponse(s.getTaskSchema))
+	s.router.HandleFunc("/tasks/{name}/schema/", jsonResponse(s.getTaskSchema)).Name("taskSchema")
+	s.router.HandleFunc("/tasks/{name}/pause/", jsonResponse(s.pauseTask)).Methods("POST")
+	s.router.HandleFunc("/tasks/{name}/resume/", jsonResponse(s.resumeTask)).Methods("POST")
 	s.router.HandleFunc("/jobs/", jsonResponse(s.listJobs)).Name("jobs")
+	s.router.HandleFunc("/jobs/{uuid}/", jsonResponse(s.cancelJob)).Methods("DELETE")
 	s.router.HandleFunc("/jobs/{uuid}/", jsonResponse(s.getJobStatus)).Name("job")
+	s.router.HandleFunc("/jobs/{uuid}/cancel/", jsonResponse(s.cancelJob)).Methods("POST")
+	s.router.HandleFunc("/jobs/{uuid}/logs/", jsonResponse(s.getJobLogs)).Name("jobLogs")
+	s.router.HandleFunc("/jobs/{uuid}/events/", s.streamJobEvents).Methods("GET")
+	s.router.HandleFunc("/events/", s.streamEvents).Methods("GET").Name("events")
+	s.router.HandleFunc("/schedules/", jsonResponse(s.listSchedules)).Name("schedules")
+	s.router.HandleFunc("/workflows/{id}/", jsonResponse(s.getWorkflow)).Name("workflow")
+	s.router.HandleFunc("/chains/", jsonResponse(s.submitChain)).Methods("POST").Name("chains")
+	s.router.HandleFunc("/chains/{id}/", jsonResponse(s.getChain)).Name("chain")
+	s.router.HandleFunc("/batches/", jsonResponse(s.submitBatch)).Methods("POST").Name("batches")
+	s.router.HandleFunc("/batches/{id}/", jsonResponse(s.getBatch)).Name("batch")
 }
 
 type NameRef struct {
 	Name string `json:"name"`
 	Href string `json:"href"`
+	// Paused is only set on the status entry of the root listing.
+	Paused *bool `json:"paused,omitempty"`
 }
 
 func (s *server) listServices(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
@@ -48,33 +72,159 @@ func (s *server) listServices(w http.ResponseWriter, r *http.Request) (data inte
 	if err != nil {
 		return
 	}
-	services := []NameRef{
-		{"tasks", tasksUrl.String()},
-		{"jobs", jobsUrl.String()},
+	schedulesUrl, err := s.router.Get("schedules").URL()
+	if err != nil {
+		return
+	}
+	queuesUrl, err := s.router.Get("queues").URL()
+	if err != nil {
+		return
+	}
+	eventsUrl, err := s.router.Get("events").URL()
+	if err != nil {
+		return
+	}
+	statusUrl, err := s.router.Get("status").URL()
+	if err != nil {
+		return
+	}
+	paused := s.taskQueue.IsPaused()
+	data = []NameRef{
+		{Name: "tasks", Href: tasksUrl.String()},
+		{Name: "jobs", Href: jobsUrl.String()},
+		{Name: "schedules", Href: schedulesUrl.String()},
+		{Name: "queues", Href: queuesUrl.String()},
+		{Name: "events", Href: eventsUrl.String()},
+		{Name: "status", Href: statusUrl.String(), Paused: &paused},
 	}
-	data = services
 	return
 }
 
+type WebQueue struct {
+	Paused bool `json:"paused"`
+	Panics int  `json:"panics"`
+}
+
+func (s *server) getStatus(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
+	data = WebQueue{
+		Paused: s.taskQueue.IsPaused(),
+		Panics: s.taskQueue.Panics(),
+	}
+	return
+}
+
+func (s *server) pauseQueue(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
+	s.taskQueue.Pause()
+	return s.getStatus(w, r)
+}
+
+func (s *server) resumeQueue(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
+	s.taskQueue.Resume()
+	return s.getStatus(w, r)
+}
+
+type WebTask struct {
+	TaskInfo
+	Href string `json:"href"`
+}
+
 func (s *server) listDefinedTasks(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
-	tasks := make([]NameRef, 0, len(s.taskQueue.tasks))
-	for key := range s.taskQueue.tasks {
-		taskUrl, err := s.router.Get("submitTask").URL("name", key)
+	infos := s.taskQueue.GetTasks()
+	queue := r.URL.Query().Get("queue")
+	tasks := make([]WebTask, 0, len(infos))
+	for _, info := range infos {
+		if queue != "" && info.Queue != queue {
+			continue
+		}
+		taskUrl, err := s.router.Get("submitTask").URL("name", info.Name)
 		if err != nil {
 			return tasks, err
 		}
-		tasks = append(tasks, NameRef{key, taskUrl.String()})
+		tasks = append(tasks, WebTask{info, taskUrl.String()})
 	}
 	data = tasks
 	return
 }
 
+func (s *server) getTaskSchema(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
+	schema, err := s.taskQueue.GetSchema(mux.Vars(r)["name"])
+	if err != nil {
+		err = &httpError{404, err}
+		return
+	}
+	data = schema
+	return
+}
+
+func (s *server) listQueues(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
+	data = s.taskQueue.GetQueues()
+	return
+}
+
+func (s *server) pauseTask(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
+	err = s.taskQueue.PauseTask(mux.Vars(r)["name"])
+	if err != nil {
+		return nil, &httpError{404, err}
+	}
+	return s.webTask(mux.Vars(r)["name"])
+}
+
+func (s *server) resumeTask(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
+	err = s.taskQueue.ResumeTask(mux.Vars(r)["name"])
+	if err != nil {
+		return nil, &httpError{404, err}
+	}
+	return s.webTask(mux.Vars(r)["name"])
+}
+
+func (s *server) webTask(name string) (data interface{}, err error) {
+	for _, info := range s.taskQueue.GetTasks() {
+		if info.Name == name {
+			taskUrl, err := s.router.Get("submitTask").URL("name", name)
+			if err != nil {
+				return nil, err
+			}
+			return WebTask{info, taskUrl.String()}, nil
+		}
+	}
+	return nil, &httpError{404, errors.New("Unknown task: " + name)}
+}
+
 func (s *server) submitTask(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
 	name := mux.Vars(r)["name"]
-	timeout, err := getTimeout(r)
+	timeout, err := getTimeout(r, "jobTimeoutSeconds")
 	if err != nil {
 		return
 	}
+	executionTimeout, err := getTimeout(r, "executionTimeoutSeconds")
+	if err != nil {
+		return nil, &httpError{400, err}
+	}
+	var options []SubmitOption
+	if executionTimeout > 0 {
+		options = append(options, ExecutionTimeout(time.Duration(executionTimeout)*time.Second))
+	}
+	runAt, err := getRunAt(r)
+	if err != nil {
+		err = &httpError{400, err}
+		return
+	}
+	if priorityParam := r.URL.Query().Get("priority"); len(priorityParam) != 0 {
+		priority, err := strconv.Atoi(priorityParam)
+		if err != nil {
+			return nil, &httpError{400, err}
+		}
+		options = append(options, Priority(priority))
+	}
+	uniqueWindow, err := getTimeout(r, "uniqueWindowSeconds")
+	if err != nil {
+		return nil, &httpError{400, err}
+	}
+	if key := r.URL.Query().Get("uniqueKey"); len(key) != 0 {
+		options = append(options, UniqueKey(key, time.Duration(uniqueWindow)*time.Second))
+	} else if r.URL.Query().Get("unique") == "true" {
+		options = append(options, Unique(time.Duration(uniqueWindow)*time.Second))
+	}
 
 	var arguments interface{}
 	if r.Header.Get("Content-Type") != "" {
@@ -90,9 +240,11 @@ func (s *server) submitTask(w http.ResponseWriter, r *http.Request) (data interf
 		}
 	}
 
-	job, err := s.taskQueue.Submit(name, arguments)
+	job, err := s.submitOnce(r.Header.Get("Idempotency-Key"), name, arguments, func() (*Job, error) {
+		return s.taskQueue.SubmitAt(name, arguments, runAt, options...)
+	})
 	if err != nil {
-		err = &httpError{404, err}
+		err = submitError(w, err, 404)
 		return
 	}
 
@@ -118,8 +270,12 @@ func (s *server) listJobs(w http.ResponseWriter, r *http.Request) (data interfac
 	if err != nil {
 		return
 	}
+	queue := r.URL.Query().Get("queue")
 	jobs := make([]interface{}, 0, len(storedJobs))
 	for _, job := range storedJobs {
+		if queue != "" && job.Queue != queue {
+			continue
+		}
 		url, err := s.router.Get("job").URL("uuid", job.UUID)
 		if err != nil {
 			return data, err
@@ -130,6 +286,36 @@ func (s *server) listJobs(w http.ResponseWriter, r *http.Request) (data interfac
 	return
 }
 
+type WebLogs struct {
+	Lines []LogLine `json:"lines"`
+	Next  string    `json:"next"`
+}
+
+// getJobLogs pages through the log of a job with the offset and limit query
+// parameters. Next continues after the last returned line.
+func (s *server) getJobLogs(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
+	uuid := mux.Vars(r)["uuid"]
+	offset, err := getInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		return nil, &httpError{400, errors.New("Invalid offset")}
+	}
+	limit, err := getInt(r, "limit", 100)
+	if err != nil || limit < 1 {
+		return nil, &httpError{400, errors.New("Invalid limit")}
+	}
+	lines, err := s.taskQueue.GetLogs(uuid, offset, limit)
+	if err != nil {
+		return nil, &httpError{404, err}
+	}
+	url, err := s.router.Get("jobLogs").URL("uuid", uuid)
+	if err != nil {
+		return
+	}
+	next := offset + len(lines)
+	data = WebLogs{lines, url.String() + "?offset=" + strconv.Itoa(next) + "&limit=" + strconv.Itoa(limit)}
+	return
+}
+
 func (s *server) getJobStatus(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
 	uuid := mux.Vars(r)["uuid"]
 	job, err := s.taskQueue.GetJob(uuid)
@@ -146,6 +332,220 @@ func (s *server) getJobStatus(w http.ResponseWriter, r *http.Request) (data inte
 	return
 }
 
+func (s *server) cancelJob(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
+	uuid := mux.Vars(r)["uuid"]
+	job, err := s.taskQueue.Cancel(uuid)
+	if err == ErrJobFinished {
+		err = &httpError{409, err}
+		return
+	}
+	if err != nil {
+		err = &httpError{404, err}
+		return
+	}
+
+	url, err := s.router.Get("job").URL("uuid", job.UUID)
+	if err != nil {
+		return data, err
+	}
+	data = WebJob{job, url.String()}
+	return
+}
+
+func (s *server) listSchedules(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
+	data = s.taskQueue.GetSchedules()
+	return
+}
+
+// WebWorkflow is the dependency graph of a workflow. Every edge points from a
+// job to a job that depends on it.
+type WebWorkflow struct {
+	ID    string    `json:"id"`
+	Jobs  []WebJob  `json:"jobs"`
+	Edges []WebEdge `json:"edges"`
+	Href  string    `json:"href"`
+}
+
+type WebEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (s *server) getWorkflow(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
+	id := mux.Vars(r)["id"]
+	storedJobs, err := s.taskQueue.GetWorkflow(id)
+	if err != nil {
+		return
+	}
+	if len(storedJobs) == 0 {
+		err = &httpError{404, errors.New("Workflow " + id + " not found")}
+		return
+	}
+
+	workflowUrl, err := s.router.Get("workflow").URL("id", id)
+	if err != nil {
+		return
+	}
+	workflow := WebWorkflow{
+		ID:    id,
+		Jobs:  make([]WebJob, 0, len(storedJobs)),
+		Edges: make([]WebEdge, 0),
+		Href:  workflowUrl.String(),
+	}
+	for _, job := range storedJobs {
+		url, err := s.router.Get("job").URL("uuid", job.UUID)
+		if err != nil {
+			return data, err
+		}
+		workflow.Jobs = append(workflow.Jobs, WebJob{job, url.String()})
+		for _, dependency := range job.Dependencies {
+			workflow.Edges = append(workflow.Edges, WebEdge{dependency, job.UUID})
+		}
+	}
+	data = workflow
+	return
+}
+
+type WebChain struct {
+	ID     string   `json:"id"`
+	Status string   `json:"status"`
+	Jobs   []WebJob `json:"jobs"`
+	Href   string   `json:"href"`
+}
+
+type ChainRequest struct {
+	Tasks     []string    `json:"tasks"`
+	Arguments interface{} `json:"arguments"`
+}
+
+func (s *server) submitChain(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
+	var request ChainRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		err = &httpError{400, err}
+		return
+	}
+	chain, err := s.taskQueue.SubmitChain(request.Tasks, request.Arguments)
+	if err != nil {
+		err = submitError(w, err, 400)
+		return
+	}
+	return s.webChain(chain)
+}
+
+func (s *server) getChain(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
+	chain, err := s.taskQueue.GetChain(mux.Vars(r)["id"])
+	if err != nil {
+		err = &httpError{404, err}
+		return
+	}
+	return s.webChain(chain)
+}
+
+func (s *server) webChain(chain *Chain) (data interface{}, err error) {
+	chainUrl, err := s.router.Get("chain").URL("id", chain.ID)
+	if err != nil {
+		return
+	}
+	webChain := WebChain{
+		ID:     chain.ID,
+		Status: chain.Status,
+		Jobs:   make([]WebJob, 0, len(chain.Jobs)),
+		Href:   chainUrl.String(),
+	}
+	for _, job := range chain.Jobs {
+		url, err := s.router.Get("job").URL("uuid", job.UUID)
+		if err != nil {
+			return data, err
+		}
+		webChain.Jobs = append(webChain.Jobs, WebJob{job, url.String()})
+	}
+	data = webChain
+	return
+}
+
+type WebBatch struct {
+	ID          string         `json:"id"`
+	OnComplete  string         `json:"onComplete,omitempty"`
+	CallbackJob string         `json:"callbackJob,omitempty"`
+	Counts      map[string]int `json:"counts"`
+	Jobs        []WebJob       `json:"jobs"`
+	Href        string         `json:"href"`
+}
+
+type BatchRequest struct {
+	Jobs       []BatchJob `json:"jobs"`
+	OnComplete string     `json:"onComplete"`
+}
+
+func (s *server) submitBatch(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
+	var request BatchRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		err = &httpError{400, err}
+		return
+	}
+	batch, err := s.taskQueue.SubmitBatch(request.Jobs, request.OnComplete)
+	if err != nil {
+		err = submitError(w, err, 400)
+		return
+	}
+	return s.webBatch(batch)
+}
+
+func (s *server) getBatch(w http.ResponseWriter, r *http.Request) (data interface{}, err error) {
+	batch, err := s.taskQueue.GetBatch(mux.Vars(r)["id"])
+	if err != nil {
+		err = &httpError{404, err}
+		return
+	}
+	return s.webBatch(batch)
+}
+
+func (s *server) webBatch(batch *Batch) (data interface{}, err error) {
+	batchUrl, err := s.router.Get("batch").URL("id", batch.ID)
+	if err != nil {
+		return
+	}
+	webBatch := WebBatch{
+		ID:          batch.ID,
+		OnComplete:  batch.OnComplete,
+		CallbackJob: batch.CallbackJob,
+		Counts:      batch.Counts,
+		Jobs:        make([]WebJob, 0, len(batch.Jobs)),
+		Href:        batchUrl.String(),
+	}
+	for _, job := range batch.Jobs {
+		url, err := s.router.Get("job").URL("uuid", job.UUID)
+		if err != nil {
+			return data, err
+		}
+		webBatch.Jobs = append(webBatch.Jobs, WebJob{job, url.String()})
+	}
+	data = webBatch
+	return
+}
+
+// submitError turns the error of a submission into an HTTP error. Errors
+// other than a full or closed queue or invalid arguments get the given
+// status.
+func submitError(w http.ResponseWriter, err error, status int) error {
+	switch err {
+	case ErrQueueFull:
+		w.Header().Set("Retry-After", "1")
+		return &httpError{503, err}
+	case ErrQueueClosed:
+		return &httpError{503, err}
+	}
+	switch err.(type) {
+	case *httpError:
+		return err
+	case *ValidationError:
+		return &httpError{400, err}
+	}
+	return &httpError{status, err}
+}
+
 type httpError struct {
 	Status int
 	Err    error
@@ -162,6 +562,12 @@ func jsonResponse(fn httpHandler) func(http.ResponseWriter, *http.Request) {
 		var err error
 		data, err := fn(w, r)
 		if e, ok := err.(*httpError); ok {
+			if v, ok := e.Err.(*ValidationError); ok {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(e.Status)
+				json.NewEncoder(w).Encode(v)
+				return
+			}
 			http.Error(w, e.Error(), e.Status)
 			return
 		}
@@ -182,8 +588,16 @@ func renderJSON(w http.ResponseWriter, data interface{}) {
 	}
 }
 
-func getTimeout(r *http.Request) (timeout int, err error) {
-	timeoutParam := r.URL.Query().Get("jobTimeoutSeconds")
+func getInt(r *http.Request, param string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(param)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func getTimeout(r *http.Request, param string) (timeout int, err error) {
+	timeoutParam := r.URL.Query().Get(param)
 	if len(timeoutParam) == 0 {
 		return
 	}
@@ -191,23 +605,45 @@ func getTimeout(r *http.Request) (timeout int, err error) {
 	return
 }
 
+// getRunAt reads when a submitted job should run from either the runAt
+// (RFC 3339) or the delaySeconds query parameter.
+func getRunAt(r *http.Request) (runAt time.Time, err error) {
+	runAt = time.Now()
+	if runAtParam := r.URL.Query().Get("runAt"); len(runAtParam) != 0 {
+		return time.Parse(time.RFC3339, runAtParam)
+	}
+	delay, err := getTimeout(r, "delaySeconds")
+	if err != nil {
+		return
+	}
+	if delay < 0 {
+		err = errors.New("delaySeconds must not be negative")
+		return
+	}
+	runAt = runAt.Add(time.Duration(delay) * time.Second)
+	return
+}
+
+// waitForJob waits until a job has finished, following its events and
+// reading it again every pollInterval.
 func waitForJob(taskQueue *TaskQueue, uuid string, timeout time.Duration) (job *Job, err error) {
-	tick := time.Tick(500 * time.Millisecond)
+	events, unsubscribe := taskQueue.Subscribe(uuid)
+	defer unsubscribe()
 	stop := time.After(timeout)
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
 
 	for {
+		job, err = taskQueue.GetJob(uuid)
+		if err != nil || job.HasFinished() {
+			return
+		}
 		select {
 		case <-stop:
-			err = &httpError{504, errors.New("Timed out waiting for job " + job.UUID)}
+			err = &httpError{504, errors.New("Timed out waiting for job " + uuid)}
 			return
-		case <-tick:
-			job, err = taskQueue.GetJob(uuid)
-			if err != nil {
-				return
-			}
-			if job.Status == JOB_SUCCESS || job.Status == JOB_FAILURE {
-				return
-			}
+		case <-events:
+		case <-poll.C:
 		}
 	}
 }
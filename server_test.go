@@ -0,0 +1,471 @@
+package tsq
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func doRequest(handler http.Handler, method string, url string) (w *httptest.ResponseRecorder) {
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+	return
+}
+
+func decodeJob(t *testing.T, w *httptest.ResponseRecorder) (job WebJob) {
+	err := json.NewDecoder(w.Body).Decode(&job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestServeCancelJob(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	handler := ServeQueue("/tsq/", tsq)
+	run1 := NewTestRun()
+	run1.shouldWait = true
+	tsq.Submit("test", run1)
+	job, _ := tsq.Submit("test", NewTestRun())
+	run1.WaitForStart(t)
+
+	w := doRequest(handler, "POST", "/tsq/jobs/"+job.UUID+"/cancel/")
+	if w.Code != 200 || decodeJob(t, w).Status != JOB_CANCELLED {
+		t.Error("cancel failed", w.Code)
+	}
+	w = doRequest(handler, "DELETE", "/tsq/jobs/"+job.UUID+"/")
+	if w.Code != 409 {
+		t.Error("expected conflict, got", w.Code)
+	}
+	w = doRequest(handler, "DELETE", "/tsq/jobs/unknown/")
+	if w.Code != 404 {
+		t.Error("expected not found, got", w.Code)
+	}
+	run1.forward <- true
+}
+
+func TestServeSubmitDelayed(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("ping", &CommandTask{Cmd: "true"})
+	handler := ServeQueue("/tsq/", tsq)
+
+	w := doRequest(handler, "POST", "/tsq/tasks/ping/?delaySeconds=60")
+	job := decodeJob(t, w)
+	if w.Code != 200 || job.Status != JOB_SCHEDULED || job.RunAt.Before(time.Now().Add(59*time.Second)) {
+		t.Error("job was not scheduled", w.Code, job.Status, job.RunAt)
+	}
+	w = doRequest(handler, "POST", "/tsq/tasks/ping/?runAt=tomorrow")
+	if w.Code != 400 {
+		t.Error("expected bad request, got", w.Code)
+	}
+	w = doRequest(handler, "POST", "/tsq/tasks/ping/?delaySeconds=-60")
+	if w.Code != 400 {
+		t.Error("expected bad request for a negative delay, got", w.Code)
+	}
+	w = doRequest(handler, "POST", "/tsq/tasks/ping/?executionTimeoutSeconds=soon")
+	if w.Code != 400 {
+		t.Error("expected bad request for an invalid timeout, got", w.Code)
+	}
+}
+
+func TestServeSubmitQueueFull(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{QueueLength: 1})
+	tsq.Define("ping", &CommandTask{Cmd: "true"})
+	handler := ServeQueue("/tsq/", tsq)
+	run := NewTestRun()
+	run.shouldWait = true
+	tsq.Submit("test", run)
+	run.WaitForStart(t)
+	tsq.Submit("test", NewTestRun())
+
+	w := doRequest(handler, "POST", "/tsq/tasks/ping/")
+	if w.Code != 503 || w.Header().Get("Retry-After") == "" {
+		t.Error("expected service unavailable, got", w.Code)
+	}
+	run.forward <- true
+}
+
+func TestServeWorkflow(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("ping", &CommandTask{Cmd: "true"})
+	handler := ServeQueue("/tsq/", tsq)
+	wf, _ := tsq.NewWorkflow()
+	build, _ := wf.Submit("ping", nil)
+	test, _ := wf.Submit("ping", nil, DependsOn(build.UUID))
+
+	w := doRequest(handler, "GET", "/tsq/workflows/"+wf.ID+"/")
+	var workflow WebWorkflow
+	json.NewDecoder(w.Body).Decode(&workflow)
+	if w.Code != 200 || len(workflow.Jobs) != 2 || len(workflow.Edges) != 1 {
+		t.Fatal("unexpected workflow", w.Code, workflow)
+	}
+	if workflow.Edges[0] != (WebEdge{build.UUID, test.UUID}) {
+		t.Error("unexpected edge", workflow.Edges[0])
+	}
+	w = doRequest(handler, "GET", "/tsq/workflows/unknown/")
+	if w.Code != 404 {
+		t.Error("expected not found, got", w.Code)
+	}
+}
+
+func TestServeChain(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("add", &AddTask{})
+	handler := ServeQueue("/tsq/", tsq)
+
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"tasks": ["add", "add"], "arguments": 1}`)
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/tsq/chains/", body))
+	var chain WebChain
+	json.NewDecoder(w.Body).Decode(&chain)
+	if w.Code != 200 || len(chain.Jobs) != 2 {
+		t.Fatal("unexpected chain", w.Code, chain)
+	}
+	WaitForStatus(t, tsq, chain.Jobs[1].UUID, JOB_SUCCESS)
+
+	w = doRequest(handler, "GET", chain.Href)
+	json.NewDecoder(w.Body).Decode(&chain)
+	if chain.Status != JOB_SUCCESS || chain.Jobs[1].Result != 3.0 {
+		t.Error("unexpected chain", chain.Status, chain.Jobs[1].Result)
+	}
+}
+
+func TestServeBatch(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("add", &AddTask{})
+	handler := ServeQueue("/tsq/", tsq)
+
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"jobs": [{"task": "add", "arguments": 1}, {"task": "add", "arguments": 2}]}`)
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/tsq/batches/", body))
+	var batch WebBatch
+	json.NewDecoder(w.Body).Decode(&batch)
+	if w.Code != 200 || len(batch.Jobs) != 2 {
+		t.Fatal("unexpected batch", w.Code, batch)
+	}
+	WaitForStatus(t, tsq, batch.Jobs[0].UUID, JOB_SUCCESS)
+	WaitForStatus(t, tsq, batch.Jobs[1].UUID, JOB_SUCCESS)
+
+	w = doRequest(handler, "GET", batch.Href)
+	json.NewDecoder(w.Body).Decode(&batch)
+	if batch.Counts[JOB_SUCCESS] != 2 {
+		t.Error("unexpected batch counts", batch.Counts)
+	}
+
+	w = doRequest(handler, "GET", "/tsq/batches/unknown/")
+	if w.Code != 404 {
+		t.Error("unexpected status for unknown batch", w.Code)
+	}
+}
+
+func TestServeUniqueJob(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsk := &BlockingTask{started: make(chan bool, 1)}
+	tsq.DefineContext("block", tsk)
+	handler := ServeQueue("/tsq/", tsq)
+
+	first := decodeJob(t, doRequest(handler, "POST", "/tsq/tasks/block/?uniqueKey=abc"))
+	second := decodeJob(t, doRequest(handler, "POST", "/tsq/tasks/block/?uniqueKey=abc"))
+	if first.UUID != second.UUID {
+		t.Error("duplicate submission created a new job", first.UUID, second.UUID)
+	}
+}
+
+func TestServeIdempotencyKey(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("add", &AddTask{})
+	handler := ServeQueue("/tsq/", tsq)
+	submit := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/tsq/tasks/add/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Idempotency-Key", "request-1")
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	first := decodeJob(t, submit("1"))
+	second := decodeJob(t, submit("1"))
+	if first.UUID == "" || second.UUID != first.UUID {
+		t.Error("repeated request created a new job", first.UUID, second.UUID)
+	}
+	w := submit("2")
+	if w.Code != 422 {
+		t.Error("unexpected status for a conflicting request", w.Code)
+	}
+	jobs, _ := tsq.GetJobs()
+	if len(jobs) != 1 {
+		t.Error("unexpected number of jobs", len(jobs))
+	}
+}
+
+type FailKeyStore struct {
+	JobStore
+}
+
+func (s FailKeyStore) StoreIdempotencyKey(key *IdempotencyKey) error {
+	return errors.New("ERROR")
+}
+
+func TestServeIdempotencyKeyStoreFailure(t *testing.T) {
+	tsq := (&Config{JobStore: FailKeyStore{NewMemoryStore()}}).NewQueue()
+	tsq.Define("add", &AddTask{})
+	tsq.Start()
+	handler := ServeQueue("/tsq/", tsq)
+	r := httptest.NewRequest("POST", "/tsq/tasks/add/", strings.NewReader("1"))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Idempotency-Key", "request-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if job := decodeJob(t, w); job.UUID == "" {
+		t.Error("queued job was not returned", w.Code)
+	}
+}
+
+func TestServeIdempotencyKeyExpiry(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{IdempotencyRetention: 10 * time.Millisecond})
+	tsq.Define("add", &AddTask{})
+	handler := ServeQueue("/tsq/", tsq)
+	submit := func(key string) WebJob {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/tsq/tasks/add/", strings.NewReader("1"))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Idempotency-Key", key)
+		handler.ServeHTTP(w, r)
+		return decodeJob(t, w)
+	}
+
+	first := submit("request-1")
+	time.Sleep(20 * time.Millisecond)
+	second := submit("request-2")
+	if _, err := tsq.jobStore.GetIdempotencyKey("request-1"); err == nil {
+		t.Error("expired key was kept")
+	}
+	if third := submit("request-1"); third.UUID == first.UUID || third.UUID == second.UUID {
+		t.Error("expired key returned an old job")
+	}
+}
+
+func TestServeTaskLimits(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("add", &AddTask{}, MaxConcurrency(2), RateLimit(time.Minute, 3))
+	handler := ServeQueue("/tsq/", tsq)
+
+	w := doRequest(handler, "GET", "/tsq/tasks/")
+	var tasks []WebTask
+	json.NewDecoder(w.Body).Decode(&tasks)
+	if len(tasks) != 2 || tasks[0].Name != "add" || tasks[0].Href != "/tsq/tasks/add/" {
+		t.Fatal("unexpected tasks", tasks)
+	}
+	if tasks[0].MaxConcurrency != 2 || tasks[0].RateLimit == nil || tasks[0].RateLimit.Tokens != 3 {
+		t.Error("unexpected task limits", tasks[0].MaxConcurrency, tasks[0].RateLimit)
+	}
+}
+
+func TestServePause(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	handler := ServeQueue("/tsq/", tsq)
+
+	w := doRequest(handler, "POST", "/tsq/pause/")
+	var queue WebQueue
+	json.NewDecoder(w.Body).Decode(&queue)
+	if w.Code != 200 || !queue.Paused || !tsq.IsPaused() {
+		t.Error("queue was not paused", w.Code, queue)
+	}
+	w = doRequest(handler, "GET", "/tsq/status/")
+	json.NewDecoder(w.Body).Decode(&queue)
+	if w.Code != 200 || !queue.Paused {
+		t.Error("status does not show a paused queue", w.Code, queue)
+	}
+	w = doRequest(handler, "GET", "/tsq/")
+	var listing []NameRef
+	json.NewDecoder(w.Body).Decode(&listing)
+	if status := listing[len(listing)-1]; status.Name != "status" || status.Paused == nil || !*status.Paused {
+		t.Error("root listing does not show a paused queue", listing)
+	}
+	doRequest(handler, "POST", "/tsq/resume/")
+	if tsq.IsPaused() {
+		t.Error("queue was not resumed")
+	}
+	w = doRequest(handler, "GET", "/tsq/")
+	var services []NameRef
+	if err := json.NewDecoder(w.Body).Decode(&services); err != nil || len(services) == 0 {
+		t.Error("unexpected service listing", err, services)
+	}
+
+	w = doRequest(handler, "POST", "/tsq/tasks/test/pause/")
+	var task WebTask
+	json.NewDecoder(w.Body).Decode(&task)
+	if w.Code != 200 || !task.Paused {
+		t.Error("task was not paused", w.Code, task)
+	}
+	w = doRequest(handler, "POST", "/tsq/tasks/unknown/pause/")
+	if w.Code != 404 {
+		t.Error("unexpected status for unknown task", w.Code)
+	}
+}
+
+func TestServeQueues(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{Queues: map[string]QueueConfig{"fast": {Workers: 2}}})
+	tsq.Define("add", &AddTask{}, InQueue("fast"))
+	handler := ServeQueue("/tsq/", tsq)
+	job, _ := tsq.Submit("add", 1.0)
+	tsq.Submit("test", NewTestRun())
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
+
+	w := doRequest(handler, "GET", "/tsq/queues/")
+	var queues []QueueInfo
+	json.NewDecoder(w.Body).Decode(&queues)
+	if len(queues) != 2 || queues[0].Name != DefaultQueue || queues[1].Workers != 2 {
+		t.Error("unexpected queues", queues)
+	}
+
+	w = doRequest(handler, "GET", "/tsq/jobs/?queue=fast")
+	var jobs []WebJob
+	json.NewDecoder(w.Body).Decode(&jobs)
+	if len(jobs) != 1 || jobs[0].UUID != job.UUID {
+		t.Error("unexpected jobs of queue", jobs)
+	}
+
+	w = doRequest(handler, "GET", "/tsq/tasks/?queue=fast")
+	var tasks []WebTask
+	json.NewDecoder(w.Body).Decode(&tasks)
+	if len(tasks) != 1 || tasks[0].Name != "add" {
+		t.Error("unexpected tasks of queue", tasks)
+	}
+}
+
+func TestServeInvalidArguments(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	DefineTyped(tsq, "area", area)
+	handler := ServeQueue("/tsq/", tsq)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/tsq/tasks/area/", strings.NewReader(`{"width": "wide"}`))
+	r.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(w, r)
+	var validation ValidationError
+	json.NewDecoder(w.Body).Decode(&validation)
+	if w.Code != 400 || len(validation.Errors) != 2 {
+		t.Error("unexpected response for invalid arguments", w.Code, validation)
+	}
+
+	w = doRequest(handler, "GET", "/tsq/tasks/area/schema")
+	var schema Schema
+	json.NewDecoder(w.Body).Decode(&schema)
+	if w.Code != 200 || schema.Type != "object" || schema.Properties["width"].Type != "number" {
+		t.Error("unexpected schema", w.Code, schema)
+	}
+	w = doRequest(handler, "GET", "/tsq/tasks/test/schema/")
+	if w.Code != 404 {
+		t.Error("unexpected status for a task without schema", w.Code)
+	}
+}
+
+func TestServeJobLogs(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.DefineContext("log", &LoggingTask{})
+	handler := ServeQueue("/tsq/", tsq)
+	job, _ := tsq.Submit("log", nil)
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
+
+	w := doRequest(handler, "GET", "/tsq/jobs/"+job.UUID+"/logs/?limit=3")
+	var logs WebLogs
+	json.NewDecoder(w.Body).Decode(&logs)
+	if w.Code != 200 || len(logs.Lines) != 3 {
+		t.Fatal("unexpected logs", w.Code, logs)
+	}
+	w = doRequest(handler, "GET", logs.Next)
+	json.NewDecoder(w.Body).Decode(&logs)
+	if len(logs.Lines) != 1 || logs.Lines[0].Message != "done" {
+		t.Error("unexpected next page", logs)
+	}
+
+	w = doRequest(handler, "GET", "/tsq/jobs/"+job.UUID+"/logs/?offset=-1")
+	if w.Code != 400 {
+		t.Error("unexpected status for a bad offset", w.Code)
+	}
+	w = doRequest(handler, "GET", "/tsq/jobs/unknown/logs/")
+	if w.Code != 404 {
+		t.Error("unexpected status for an unknown job", w.Code)
+	}
+}
+
+func TestServeJobEvents(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	handler := ServeQueue("/tsq/", tsq)
+	run := NewTestRun()
+	run.shouldWait = true
+	job, _ := tsq.Submit("test", run)
+	run.WaitForStart(t)
+
+	w := httptest.NewRecorder()
+	done := make(chan bool)
+	go func() {
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/tsq/jobs/"+job.UUID+"/events/", nil))
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		tsq.events.mutex.Lock()
+		subscribed := len(tsq.events.subscribers) > 0
+		tsq.events.mutex.Unlock()
+		if subscribed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	run.forward <- true
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("event stream did not end with the job")
+	}
+	body := w.Body.String()
+	if w.Header().Get("Content-Type") != "text/event-stream" || !strings.HasPrefix(body, "event: status\ndata: ") ||
+		!strings.Contains(body, `"status":"RUNNING"`) || !strings.Contains(body, `"status":"SUCCESS"`) {
+		t.Error("unexpected event stream", body)
+	}
+
+	w = doRequest(handler, "GET", "/tsq/jobs/unknown/events/")
+	if w.Code != 404 {
+		t.Error("unexpected status for an unknown job", w.Code)
+	}
+}
+
+func TestServeJobEventsDropped(t *testing.T) {
+	interval := pollInterval
+	pollInterval = 10 * time.Millisecond
+	defer func() { pollInterval = interval }()
+	tsq := NewTestQueueWithConfig(Config{})
+	handler := ServeQueue("/tsq/", tsq)
+	run := NewTestRun()
+	run.shouldWait = true
+	job, _ := tsq.Submit("test", run)
+	run.WaitForStart(t)
+	defer func() { run.forward <- true }()
+
+	w := httptest.NewRecorder()
+	done := make(chan bool)
+	go func() {
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/tsq/jobs/"+job.UUID+"/events/", nil))
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	// Finish the job behind the back of the event bus.
+	tsq.jobStore.(*eventStore).JobStore.SetStatus(job.UUID, JOB_SUCCESS, time.Now())
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("event stream did not end with the job")
+	}
+	if !strings.Contains(w.Body.String(), `"status":"SUCCESS"`) {
+		t.Error("unexpected event stream", w.Body.String())
+	}
+	if _, err := waitForJob(tsq, job.UUID, time.Second); err != nil {
+		t.Error("job was not seen to finish:", err)
+	}
+}
@@ -0,0 +1,63 @@
+package tsq
+
+import (
+	"context"
+)
+
+// Shutdown stops the queue gracefully. It stops accepting and starting jobs,
+// waits until the running jobs have finished and cancels the jobs that are
+// still running when ctx is done. The job store is stopped last, so pending
+// jobs are kept for the next Start.
+func (q *TaskQueue) Shutdown(ctx context.Context) (err error) {
+	q.jobMutex.Lock()
+	select {
+	case <-q.closed:
+		q.jobMutex.Unlock()
+		return ErrQueueClosed
+	default:
+	}
+	close(q.closed)
+	q.paused = true
+	q.jobMutex.Unlock()
+
+	drained := make(chan bool)
+	go func() {
+		q.inFlight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		q.abandon()
+	}
+
+	q.cancel()
+	q.stopQueue <- true
+	q.jobStore.Stop()
+	return
+}
+
+// isClosed tells whether Shutdown has been called.
+func (q *TaskQueue) isClosed() bool {
+	select {
+	case <-q.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// abandon cancels all running jobs and records their status right away, as
+// their runners may not return in time. run ignores the outcome of abandoned
+// jobs.
+func (q *TaskQueue) abandon() {
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
+	for uuid, cancel := range q.executions {
+		cancel()
+		q.forget(uuid)
+		q.jobStore.SetResult(uuid, "Job cancelled by shutdown")
+		q.finish(uuid, JOB_CANCELLED)
+	}
+}
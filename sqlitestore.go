@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	_ "github.com/mattn/go-sqlite3"
+	"strings"
 	"time"
 )
 
@@ -30,6 +32,129 @@ func CreateJobDB(db *sql.DB) (err error) {
 	return
 }
 
+func AddJobAttempts(db *sql.DB) (err error) {
+	_, err = db.Exec("alter table Job add column attempts integer not null default 0")
+	if err != nil {
+		return
+	}
+	_, err = db.Exec("alter table Job add column errors text")
+	return
+}
+
+func AddJobTimeout(db *sql.DB) (err error) {
+	_, err = db.Exec("alter table Job add column timeout integer not null default 0")
+	return
+}
+
+func AddJobRunAt(db *sql.DB) (err error) {
+	_, err = db.Exec("alter table Job add column run_at datetime")
+	if err != nil {
+		return
+	}
+	_, err = db.Exec("update Job set run_at = created")
+	return
+}
+
+func AddJobPriority(db *sql.DB) (err error) {
+	_, err = db.Exec("alter table Job add column priority integer not null default 0")
+	return
+}
+
+func CreateScheduleDB(db *sql.DB) (err error) {
+	_, err = db.Exec(`create table Schedule (
+		name text not null primary key,
+		task text not null,
+		spec text not null,
+		arguments text,
+		last_run datetime,
+		last_job text,
+		next_run datetime
+	)`)
+	return
+}
+
+func AddJobWorkflow(db *sql.DB) (err error) {
+	_, err = db.Exec("alter table Job add column workflow text")
+	if err != nil {
+		return
+	}
+	_, err = db.Exec(`create table JobDependency (
+		job text not null references Job(uuid),
+		dependency text not null references Job(uuid),
+		primary key (job, dependency)
+	)`)
+	return
+}
+
+func AddJobArgumentsFrom(db *sql.DB) (err error) {
+	_, err = db.Exec("alter table Job add column arguments_from text references Job(uuid)")
+	return
+}
+
+func CreateBatchDB(db *sql.DB) (err error) {
+	_, err = db.Exec(`create table Batch (
+		id text not null primary key,
+		on_complete text,
+		callback_job text,
+		created datetime not null
+	)`)
+	if err != nil {
+		return
+	}
+	_, err = db.Exec("alter table Job add column batch text references Batch(id)")
+	return
+}
+
+func CreateJobUniqueDB(db *sql.DB) (err error) {
+	_, err = db.Exec(`create table JobUnique (
+		key text not null primary key,
+		job text not null references Job(uuid),
+		until datetime not null
+	)`)
+	if err != nil {
+		return
+	}
+	_, err = db.Exec("alter table Job add column unique_key text")
+	return
+}
+
+func CreateIdempotencyKeyDB(db *sql.DB) (err error) {
+	_, err = db.Exec(`create table IdempotencyKey (
+		key text not null primary key,
+		fingerprint text not null,
+		job text not null references Job(uuid),
+		created datetime not null
+	)`)
+	return
+}
+
+func AddJobQueue(db *sql.DB) (err error) {
+	_, err = db.Exec("alter table Job add column queue text")
+	return
+}
+
+func AddJobProgress(db *sql.DB) (err error) {
+	_, err = db.Exec("alter table Job add column progress text")
+	return
+}
+
+func CreateJobLogDB(db *sql.DB) (err error) {
+	_, err = db.Exec(`create table JobLog (
+		job text not null references Job(uuid),
+		line integer not null,
+		time datetime not null,
+		message text not null,
+		primary key (job, line)
+	)`)
+	return
+}
+
+const jobColumns = "uuid, name, status, arguments, result, attempts, errors, timeout, run_at, priority, workflow, arguments_from, batch, unique_key, queue, progress, created, updated"
+
+const selectJobs = "select " + jobColumns + `,
+	(select group_concat(dependency) from JobDependency where JobDependency.job = Job.uuid)
+	from Job`
+
 func (s *SQLiteStore) Start() (err error) {
 	s.db, err = sql.Open("sqlite3", s.path)
 	if err != nil {
@@ -39,8 +164,24 @@ func (s *SQLiteStore) Start() (err error) {
 	if err != nil {
 		return
 	}
+	// SQLite allows a single writer. Sharing one connection serializes
+	// transactions instead of failing them with "database is locked".
+	s.db.SetMaxOpenConns(1)
 	migrations := NewMigrations(s.db)
 	migrations.Register("V1__001_CreateJobDB", CreateJobDB)
+	migrations.Register("V1__002_AddJobAttempts", AddJobAttempts)
+	migrations.Register("V1__003_AddJobTimeout", AddJobTimeout)
+	migrations.Register("V1__004_AddJobRunAt", AddJobRunAt)
+	migrations.Register("V1__005_CreateScheduleDB", CreateScheduleDB)
+	migrations.Register("V1__006_AddJobPriority", AddJobPriority)
+	migrations.Register("V1__007_AddJobWorkflow", AddJobWorkflow)
+	migrations.Register("V1__008_AddJobArgumentsFrom", AddJobArgumentsFrom)
+	migrations.Register("V1__009_CreateBatchDB", CreateBatchDB)
+	migrations.Register("V1__010_CreateJobUniqueDB", CreateJobUniqueDB)
+	migrations.Register("V1__011_CreateIdempotencyKeyDB", CreateIdempotencyKeyDB)
+	migrations.Register("V1__012_AddJobQueue", AddJobQueue)
+	migrations.Register("V1__013_AddJobProgress", AddJobProgress)
+	migrations.Register("V1__014_CreateJobLogDB", CreateJobLogDB)
 	err = migrations.Run()
 	return
 }
@@ -50,6 +191,101 @@ func (s *SQLiteStore) Stop() {
 }
 
 func (s *SQLiteStore) Store(job *Job) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	err = insertJob(tx, job)
+	if err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) StoreJobs(jobs []*Job) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	for _, job := range jobs {
+		err = insertJob(tx, job)
+		if err != nil {
+			return
+		}
+	}
+	return tx.Commit()
+}
+
+// StoreUnique relies on the primary key of JobUnique, so that concurrent
+// submitters of the same key cannot both store a job.
+func (s *SQLiteStore) StoreUnique(job *Job, until time.Time) (*Job, error) {
+	stored, err := s.storeUnique(job, until)
+	if err != nil {
+		// Another submitter may have claimed the key first.
+		if holder, holderErr := s.getUniqueHolder(job.UniqueKey); holderErr == nil {
+			return holder, nil
+		}
+		return nil, err
+	}
+	return stored, nil
+}
+
+func (s *SQLiteStore) storeUnique(job *Job, until time.Time) (stored *Job, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	var holder string
+	var holderUntil time.Time
+	err = tx.QueryRow("select job, until from JobUnique where key = ?", job.UniqueKey).Scan(&holder, &holderUntil)
+	if err == nil {
+		existing, err := readJob(tx.QueryRow(selectJobs+" where uuid = ?", holder))
+		if err != nil {
+			return nil, err
+		}
+		if holderUntil.After(time.Now()) || !existing.HasFinished() {
+			return &existing, nil
+		}
+		_, err = tx.Exec("delete from JobUnique where key = ?", job.UniqueKey)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != sql.ErrNoRows {
+		return
+	}
+	err = insertJob(tx, job)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec("insert into JobUnique (key, job, until) values (?, ?, ?)", job.UniqueKey, job.UUID, until)
+	if err != nil {
+		return
+	}
+	return job, tx.Commit()
+}
+
+// getUniqueHolder returns the job holding a key, unless it no longer holds it.
+func (s *SQLiteStore) getUniqueHolder(key string) (*Job, error) {
+	var holder string
+	var until time.Time
+	err := s.db.QueryRow("select job, until from JobUnique where key = ?", key).Scan(&holder, &until)
+	if err != nil {
+		return nil, err
+	}
+	job, err := s.GetJob(holder)
+	if err != nil {
+		return nil, err
+	}
+	if !until.After(time.Now()) && job.HasFinished() {
+		return nil, errors.New("Unique key " + key + " was released")
+	}
+	return job, nil
+}
+
+func insertJob(tx *sql.Tx, job *Job) (err error) {
 	arguments, err := encode(job.Arguments)
 	if err != nil {
 		return
@@ -58,19 +294,147 @@ func (s *SQLiteStore) Store(job *Job) (err error) {
 	if err != nil {
 		return
 	}
-	_, err = s.db.Exec(`insert into Job (uuid, name, status, arguments, result, created, updated)
-			            values (?, ?, ?, ?, ?, ?, ?)`,
-		job.UUID, job.Name, job.Status, toNullString(arguments), toNullString(result), job.Created, job.Updated)
+	errs, err := encode(job.Errors)
+	if err != nil {
+		return
+	}
+	var progress string
+	if job.Progress != nil {
+		progress, err = encode(job.Progress)
+		if err != nil {
+			return
+		}
+	}
+	_, err = tx.Exec(`insert into Job (`+jobColumns+`)
+			            values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.UUID, job.Name, job.Status, toNullString(arguments), toNullString(result),
+		job.Attempts, toNullString(errs), job.Timeout, job.RunAt, job.Priority,
+		toNullString(job.Workflow), toNullString(job.ArgumentsFrom), toNullString(job.Batch),
+		toNullString(job.UniqueKey), toNullString(job.Queue), toNullString(progress), job.Created, job.Updated)
+	if err != nil {
+		return
+	}
+	for _, dependency := range job.Dependencies {
+		_, err = tx.Exec("insert into JobDependency (job, dependency) values (?, ?)", job.UUID, dependency)
+		if err != nil {
+			return
+		}
+	}
 	return
 }
 
 func (s *SQLiteStore) GetJobs() (jobs []*Job, err error) {
-	rows, err := s.db.Query("select uuid, name, status, arguments, result, created, updated from Job order by created desc")
+	rows, err := s.db.Query(selectJobs + " order by created desc")
 	if err != nil {
 		return
 	}
 	defer rows.Close()
+	return readJobs(rows)
+}
 
+func (s *SQLiteStore) GetJobsByStatus(statuses ...string) (jobs []*Job, err error) {
+	placeholders := make([]string, len(statuses))
+	args := make([]interface{}, len(statuses))
+	for i, status := range statuses {
+		placeholders[i] = "?"
+		args[i] = status
+	}
+	rows, err := s.db.Query(selectJobs+" where status in ("+strings.Join(placeholders, ", ")+") order by created", args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return readJobs(rows)
+}
+
+func (s *SQLiteStore) GetJobsByWorkflow(workflow string) (jobs []*Job, err error) {
+	rows, err := s.db.Query(selectJobs+" where workflow = ? order by created", workflow)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return readJobs(rows)
+}
+
+func (s *SQLiteStore) GetJob(uuid string) (*Job, error) {
+	job, err := readJob(s.db.QueryRow(selectJobs+" where uuid = ?", uuid))
+	return &job, err
+}
+
+func (s *SQLiteStore) SetStatus(uuid string, status string, updated time.Time) (err error) {
+	_, err = s.db.Exec("update Job set status = ?, updated = ? where uuid = ?", status, updated, uuid)
+	return
+}
+
+func (s *SQLiteStore) SetProgress(uuid string, progress Progress) (err error) {
+	value, err := encode(progress)
+	if err != nil {
+		return
+	}
+	_, err = s.db.Exec("update Job set progress = ? where uuid = ?", value, uuid)
+	return
+}
+
+func (s *SQLiteStore) AppendLog(uuid string, line *LogLine) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow("select count(*) from JobLog where job = ?", uuid).Scan(&line.Offset)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec("insert into JobLog (job, line, time, message) values (?, ?, ?, ?)",
+		uuid, line.Offset, line.Time, line.Message)
+	if err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) GetLogs(uuid string, offset int, limit int) (lines []LogLine, err error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := s.db.Query("select line, time, message from JobLog where job = ? order by line limit ? offset ?",
+		uuid, limit, offset)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	lines = make([]LogLine, 0)
+	for rows.Next() {
+		var line LogLine
+		err = rows.Scan(&line.Offset, &line.Time, &line.Message)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SQLiteStore) SetResult(uuid string, result interface{}) (err error) {
+	value, err := encode(result)
+	if err != nil {
+		return
+	}
+	_, err = s.db.Exec("update Job set result = ? where uuid = ?", toNullString(value), uuid)
+	return
+}
+
+func (s *SQLiteStore) SetAttempts(uuid string, attempts int, errors []string) (err error) {
+	value, err := encode(errors)
+	if err != nil {
+		return
+	}
+	_, err = s.db.Exec("update Job set attempts = ?, errors = ? where uuid = ?", attempts, toNullString(value), uuid)
+	return
+}
+
+func readJobs(rows *sql.Rows) (jobs []*Job, err error) {
 	jobs = make([]*Job, 0)
 	for rows.Next() {
 		job, readErr := readJob(rows)
@@ -79,25 +443,133 @@ func (s *SQLiteStore) GetJobs() (jobs []*Job, err error) {
 		}
 		jobs = append(jobs, &job)
 	}
+	err = rows.Err()
 	return
 }
 
-func (s *SQLiteStore) GetJob(uuid string) (*Job, error) {
-	job, err := readJob(s.db.QueryRow("select uuid, name, status, arguments, result, created, updated from Job where uuid = ?", uuid))
-	return &job, err
+func (s *SQLiteStore) StoreSchedule(schedule *Schedule) (err error) {
+	arguments, err := encode(schedule.Arguments)
+	if err != nil {
+		return
+	}
+	_, err = s.db.Exec(`insert or replace into Schedule (name, task, spec, arguments, last_run, last_job, next_run)
+			            values (?, ?, ?, ?, ?, ?, ?)`,
+		schedule.Name, schedule.Task, schedule.Spec, toNullString(arguments),
+		schedule.LastRun, schedule.LastJob, schedule.NextRun)
+	return
 }
 
-func (s *SQLiteStore) SetStatus(uuid string, status string, updated time.Time) (err error) {
-	_, err = s.db.Exec("update Job set status = ?, updated = ? where uuid = ?", status, updated, uuid)
+func (s *SQLiteStore) GetSchedules() (schedules []*Schedule, err error) {
+	rows, err := s.db.Query("select name, task, spec, arguments, last_run, last_job, next_run from Schedule order by name")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	schedules = make([]*Schedule, 0)
+	for rows.Next() {
+		schedule := &Schedule{}
+		var arguments sql.NullString
+		err = rows.Scan(&schedule.Name, &schedule.Task, &schedule.Spec, &arguments,
+			&schedule.LastRun, &schedule.LastJob, &schedule.NextRun)
+		if err != nil {
+			return nil, err
+		}
+		schedule.Arguments, err = decode(arguments)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	err = rows.Err()
 	return
 }
 
-func (s *SQLiteStore) SetResult(uuid string, result interface{}) (err error) {
-	value, err := encode(result)
+func (s *SQLiteStore) StoreBatch(batch *Batch) (err error) {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return
 	}
-	_, err = s.db.Exec("update Job set result = ? where uuid = ?", toNullString(value), uuid)
+	defer tx.Rollback()
+	_, err = tx.Exec("insert into Batch (id, on_complete, callback_job, created) values (?, ?, ?, ?)",
+		batch.ID, toNullString(batch.OnComplete), toNullString(batch.CallbackJob), batch.Created)
+	if err != nil {
+		return
+	}
+	for _, job := range batch.Jobs {
+		err = insertJob(tx, job)
+		if err != nil {
+			return
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) GetBatch(id string) (batch *Batch, err error) {
+	batch = &Batch{}
+	var onComplete sql.NullString
+	var callbackJob sql.NullString
+	err = s.db.QueryRow("select id, on_complete, callback_job, created from Batch where id = ?", id).
+		Scan(&batch.ID, &onComplete, &callbackJob, &batch.Created)
+	if err != nil {
+		return nil, err
+	}
+	batch.OnComplete = onComplete.String
+	batch.CallbackJob = callbackJob.String
+	return
+}
+
+func (s *SQLiteStore) GetUnsettledBatches() (batches []*Batch, err error) {
+	rows, err := s.db.Query("select id, on_complete, created from Batch where on_complete is not null and callback_job is null order by created")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	batches = make([]*Batch, 0)
+	for rows.Next() {
+		batch := &Batch{}
+		err = rows.Scan(&batch.ID, &batch.OnComplete, &batch.Created)
+		if err != nil {
+			return
+		}
+		batches = append(batches, batch)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *SQLiteStore) GetJobsByBatch(id string) (jobs []*Job, err error) {
+	rows, err := s.db.Query(selectJobs+" where batch = ? order by created", id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	return readJobs(rows)
+}
+
+func (s *SQLiteStore) SetBatchCallback(id string, uuid string) (err error) {
+	_, err = s.db.Exec("update Batch set callback_job = ? where id = ?", uuid, id)
+	return
+}
+
+func (s *SQLiteStore) StoreIdempotencyKey(key *IdempotencyKey) (err error) {
+	_, err = s.db.Exec("insert or replace into IdempotencyKey (key, fingerprint, job, created) values (?, ?, ?, ?)",
+		key.Key, key.Fingerprint, key.Job, key.Created)
+	return
+}
+
+func (s *SQLiteStore) GetIdempotencyKey(key string) (*IdempotencyKey, error) {
+	stored := &IdempotencyKey{}
+	err := s.db.QueryRow("select key, fingerprint, job, created from IdempotencyKey where key = ?", key).
+		Scan(&stored.Key, &stored.Fingerprint, &stored.Job, &stored.Created)
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+func (s *SQLiteStore) DeleteIdempotencyKeys(before time.Time) (err error) {
+	_, err = s.db.Exec("delete from IdempotencyKey where created < ?", before)
 	return
 }
 
@@ -109,10 +581,41 @@ func readJob(row SQLRow) (job Job, err error) {
 	job = Job{}
 	var arguments sql.NullString
 	var result sql.NullString
-	err = row.Scan(&job.UUID, &job.Name, &job.Status, &arguments, &result, &job.Created, &job.Updated)
+	var errs sql.NullString
+	var workflow sql.NullString
+	var argumentsFrom sql.NullString
+	var batch sql.NullString
+	var uniqueKey sql.NullString
+	var queue sql.NullString
+	var progress sql.NullString
+	var dependencies sql.NullString
+	err = row.Scan(&job.UUID, &job.Name, &job.Status, &arguments, &result,
+		&job.Attempts, &errs, &job.Timeout, &job.RunAt, &job.Priority, &workflow,
+		&argumentsFrom, &batch, &uniqueKey, &queue, &progress, &job.Created, &job.Updated, &dependencies)
 	if err != nil {
 		return
 	}
+	job.Workflow = workflow.String
+	job.ArgumentsFrom = argumentsFrom.String
+	job.Batch = batch.String
+	job.UniqueKey = uniqueKey.String
+	job.Queue = queue.String
+	if dependencies.Valid {
+		job.Dependencies = strings.Split(dependencies.String, ",")
+	}
+	if errs.Valid {
+		err = json.Unmarshal([]byte(errs.String), &job.Errors)
+		if err != nil {
+			return
+		}
+	}
+	if progress.Valid {
+		job.Progress = &Progress{}
+		err = json.Unmarshal([]byte(progress.String), job.Progress)
+		if err != nil {
+			return
+		}
+	}
 	job.Arguments, err = decode(arguments)
 	if err != nil {
 		return
@@ -0,0 +1,186 @@
+package tsq
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func NewTestSQLiteStore(t *testing.T, path string) *SQLiteStore {
+	store := &SQLiteStore{path: path}
+	if err := store.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestSQLiteStoreJob(t *testing.T) {
+	store := NewTestSQLiteStore(t, t.TempDir()+"/tsq.sqlite3")
+	defer store.Stop()
+	now := time.Now().UTC().Round(time.Millisecond)
+	job := &Job{
+		UUID:          "b",
+		Name:          "add",
+		Queue:         "default",
+		Status:        JOB_WAITING,
+		Arguments:     map[string]interface{}{"n": 1.0},
+		Result:        "partial",
+		Attempts:      2,
+		Errors:        []string{"ERROR"},
+		Timeout:       time.Minute,
+		RunAt:         now,
+		Priority:      3,
+		Workflow:      "workflow",
+		Dependencies:  []string{"a"},
+		ArgumentsFrom: "a",
+		Batch:         "batch",
+		UniqueKey:     "key",
+		Progress:      &Progress{Percent: 50, Step: "copy", Updated: now},
+		Created:       now,
+		Updated:       now,
+	}
+	if err := store.Store(job); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.GetJob("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored, job) {
+		t.Errorf("stored job differs:\n%+v\n%+v", stored, job)
+	}
+}
+
+func TestSQLiteStoreRestart(t *testing.T) {
+	path := t.TempDir() + "/tsq.sqlite3"
+	store := NewTestSQLiteStore(t, path)
+	now := time.Now()
+	pending := &Job{UUID: "pending", Name: "add", Status: JOB_PENDING, Arguments: 1.0, RunAt: now, Created: now}
+	interrupted := &Job{UUID: "interrupted", Name: "add", Status: JOB_RUNNING, Arguments: 1.0, RunAt: now, Created: now}
+	store.Store(pending)
+	store.Store(interrupted)
+	store.Stop()
+
+	tsq := (&Config{JobStore: &SQLiteStore{path: path}}).NewQueue()
+	tsq.Define("add", &AddTask{})
+	if err := tsq.Start(); err != nil {
+		t.Fatal(err)
+	}
+	WaitForStatus(t, tsq, interrupted.UUID, JOB_FAILURE)
+	WaitForStatus(t, tsq, pending.UUID, JOB_SUCCESS)
+	if job, _ := tsq.GetJob(pending.UUID); job.Result != 2.0 {
+		t.Error("unexpected result of a recovered job", job.Result)
+	}
+	scheduled, err := tsq.SubmitAfter("add", 1.0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := tsq.SubmitChain([]string{"add", "add"}, "one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	WaitForStatus(t, tsq, chain.Jobs[1].UUID, JOB_SKIPPED)
+	tsq.Stop()
+
+	tsq = (&Config{JobStore: &SQLiteStore{path: path}, RecoveryPolicy: RECOVER_REQUEUE}).NewQueue()
+	tsq.Define("add", &AddTask{})
+	if err := tsq.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer tsq.Stop()
+	WaitForStatus(t, tsq, scheduled.UUID, JOB_SCHEDULED)
+	chain, err = tsq.GetChain(chain.ID)
+	if err != nil || chain.Status != JOB_FAILURE || chain.Jobs[1].ArgumentsFrom != chain.Jobs[0].UUID {
+		t.Error("unexpected chain after restart", chain, err)
+	}
+}
+
+func TestSQLiteStoreUniqueKey(t *testing.T) {
+	tsq := (&Config{JobStore: NewTestSQLiteStore(t, t.TempDir()+"/tsq.sqlite3"), QueueLength: 50}).NewQueue()
+	tsq.Define("add", &AddTask{})
+	if err := tsq.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer tsq.Stop()
+	var wg sync.WaitGroup
+	uuids := make(chan string, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			job, err := tsq.Submit("add", 1.0, Unique(time.Minute))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			uuids <- job.UUID
+		}()
+	}
+	wg.Wait()
+	close(uuids)
+	seen := make(map[string]bool)
+	for uuid := range uuids {
+		seen[uuid] = true
+	}
+	if jobs, _ := tsq.GetJobs(); len(seen) != 1 || len(jobs) != 1 {
+		t.Error("unique job was stored more than once", len(seen), len(jobs))
+	}
+}
+
+func TestSQLiteStoreLogs(t *testing.T) {
+	tsq := (&Config{JobStore: NewTestSQLiteStore(t, t.TempDir()+"/tsq.sqlite3")}).NewQueue()
+	tsq.DefineContext("log", &LoggingTask{})
+	if err := tsq.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer tsq.Stop()
+	job, _ := tsq.Submit("log", nil)
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
+	lines, err := tsq.GetLogs(job.UUID, 1, 2)
+	if err != nil || len(lines) != 2 || lines[0].Offset != 1 || lines[1].Message != "step 2" || lines[0].Time.IsZero() {
+		t.Error("unexpected page of log lines", lines, err)
+	}
+	if lines, _ = tsq.GetLogs(job.UUID, 0, 0); len(lines) != 4 {
+		t.Error("unexpected log lines", lines)
+	}
+}
+
+func TestSQLiteStoreBatch(t *testing.T) {
+	store := NewTestSQLiteStore(t, t.TempDir()+"/tsq.sqlite3")
+	defer store.Stop()
+	now := time.Now()
+	job := &Job{UUID: "a", Name: "add", Status: JOB_SUCCESS, Batch: "batch", RunAt: now, Created: now}
+	err := store.StoreBatch(&Batch{ID: "batch", OnComplete: "collect", Created: now, Jobs: []*Job{job}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches, err := store.GetUnsettledBatches()
+	if err != nil || len(batches) != 1 || batches[0].OnComplete != "collect" {
+		t.Error("unexpected unsettled batches", batches, err)
+	}
+	store.SetBatchCallback("batch", "callback")
+	if batches, _ = store.GetUnsettledBatches(); len(batches) != 0 {
+		t.Error("settled batch was returned", batches)
+	}
+	if jobs, _ := store.GetJobsByBatch("batch"); len(jobs) != 1 || jobs[0].UUID != "a" {
+		t.Error("unexpected jobs of batch", jobs)
+	}
+}
+
+func TestSQLiteStoreIdempotencyKeys(t *testing.T) {
+	store := NewTestSQLiteStore(t, t.TempDir()+"/tsq.sqlite3")
+	defer store.Stop()
+	now := time.Now()
+	store.StoreIdempotencyKey(&IdempotencyKey{"old", "fingerprint", "a", now.Add(-time.Hour)})
+	store.StoreIdempotencyKey(&IdempotencyKey{"new", "fingerprint", "b", now})
+	if err := store.DeleteIdempotencyKeys(now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetIdempotencyKey("old"); err == nil {
+		t.Error("expired key was kept")
+	}
+	if key, err := store.GetIdempotencyKey("new"); err != nil || key.Job != "b" {
+		t.Error("unexpected key", key, err)
+	}
+}
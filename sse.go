@@ -0,0 +1,106 @@
+package tsq
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// streamEvents sends the events of all jobs as Server-Sent Events until the
+// client goes away.
+func (s *server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe := s.taskQueue.Subscribe("")
+	defer unsubscribe()
+	if !startEventStream(w) {
+		return
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			writeEvent(w, event)
+		}
+	}
+}
+
+// streamJobEvents sends the events of a single job as Server-Sent Events. The
+// stream starts with the current status of the job and ends once the job
+// has finished. The job is read again every pollInterval, so that the stream
+// also ends when the bus dropped its last status event.
+func (s *server) streamJobEvents(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+	events, unsubscribe := s.taskQueue.Subscribe(uuid)
+	defer unsubscribe()
+	job, err := s.taskQueue.GetJob(uuid)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if !startEventStream(w) {
+		return
+	}
+	writeEvent(w, Event{Type: EVENT_STATUS, Job: uuid, Status: job.Status, Time: job.Updated})
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	finished := job.HasFinished()
+	for !finished {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			writeEvent(w, event)
+			finished = event.Type == EVENT_STATUS && isFinal(event.Status)
+		case <-poll.C:
+			job, err = s.taskQueue.GetJob(uuid)
+			if err == nil && job.HasFinished() {
+				finished = drainEvents(w, events)
+				if !finished {
+					writeEvent(w, Event{Type: EVENT_STATUS, Job: uuid, Status: job.Status, Time: job.Updated})
+					finished = true
+				}
+			}
+		}
+	}
+}
+
+// drainEvents writes the events that are already buffered and tells whether
+// one of them was a final status.
+func drainEvents(w http.ResponseWriter, events <-chan Event) bool {
+	for {
+		select {
+		case event := <-events:
+			writeEvent(w, event)
+			if event.Type == EVENT_STATUS && isFinal(event.Status) {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+}
+
+func startEventStream(w http.ResponseWriter) bool {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", 500)
+		return false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	flusher.Flush()
+	return true
+}
+
+func writeEvent(w http.ResponseWriter, event Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	w.(http.Flusher).Flush()
+}
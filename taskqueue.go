@@ -1,53 +1,258 @@
 package tsq
 
 import (
+	"context"
 	"errors"
+	"log"
+	"runtime/debug"
+	"sync"
 	"time"
 )
 
 type TaskQueue struct {
 	stopQueue chan bool
-	tasks     map[string]Runner
-	jobQueue  chan *Job
+	tasks     map[string]*task
+	lanes     map[string]*lane
+	wakeup    chan bool
+	finished  chan *Job
 	jobStore  JobStore
+	events    *eventBus
+	aging     time.Duration
+	recovery  RecoveryPolicy
+	retention time.Duration
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	jobMutex   sync.Mutex
+	pending    pendingJobs
+	sequence   uint64
+	executions map[string]context.CancelFunc
+	cancelled  map[string]bool
+	waiting    map[string]*Job
+	limitTimer *time.Timer
+	paused     bool
+	closed     chan bool
+	inFlight   sync.WaitGroup
+	panics     int
+
+	scheduleMutex sync.Mutex
+	schedules     map[string]*Schedule
+	started       bool
+}
+
+var (
+	ErrJobFinished = errors.New("Job has already finished")
+	ErrQueueFull   = errors.New("Queue is full")
+	ErrQueueClosed = errors.New("Queue is shutting down")
+)
+
+type task struct {
+	runner      ContextRunner
+	concurrency int
+	running     int
+	retry       *RetryPolicy
+	timeout     time.Duration
+	limit       *tokenBucket
+	paused      bool
+	queue       string
+	lane        *lane
+	validate    func(arguments interface{}) error
+	schema      *Schema
+}
+
+type TaskOption func(*task)
+
+// MaxConcurrency limits the number of jobs of a task that run at the same time.
+func MaxConcurrency(limit int) TaskOption {
+	return func(t *task) {
+		t.concurrency = limit
+	}
+}
+
+// Timeout limits how long a job of a task may run, unless the job was
+// submitted with its own ExecutionTimeout.
+func Timeout(timeout time.Duration) TaskOption {
+	return func(t *task) {
+		t.timeout = timeout
+	}
+}
+
+func (t *task) hasCapacity() bool {
+	if t.lane.running >= t.lane.workers {
+		return false
+	}
+	return t.concurrency <= 0 || t.running < t.concurrency
 }
 
 func New() *TaskQueue {
 	return DefaultConfig.NewQueue()
 }
 
-func (q *TaskQueue) Define(name string, r Runner) {
-	q.tasks[name] = r
+// Define registers a task. Runners that also implement ContextRunner are run
+// through RunContext.
+func (q *TaskQueue) Define(name string, r Runner, options ...TaskOption) {
+	q.DefineContext(name, AdaptRunner(r), options...)
+}
+
+// DefineContext registers a task that is run through RunContext. It panics
+// when the task is assigned to a queue that is not configured.
+func (q *TaskQueue) DefineContext(name string, r ContextRunner, options ...TaskOption) {
+	t := &task{runner: r, queue: DefaultQueue}
+	for _, option := range options {
+		option(t)
+	}
+	l, ok := q.lanes[t.queue]
+	if !ok {
+		panic("Unknown queue for task " + name + ": " + t.queue)
+	}
+	t.lane = l
+	q.tasks[name] = t
+}
+
+type SubmitOption func(*Job)
+
+// Priority makes a job run before pending jobs with a lower priority.
+func Priority(priority int) SubmitOption {
+	return func(job *Job) {
+		job.Priority = priority
+	}
+}
+
+// ExecutionTimeout overrides the timeout of the task for a single job.
+func ExecutionTimeout(timeout time.Duration) SubmitOption {
+	return func(job *Job) {
+		job.Timeout = timeout
+	}
+}
+
+// Submit queues a job without waiting. It fails with ErrQueueFull when
+// QueueLength jobs are already waiting for a worker.
+func (q *TaskQueue) Submit(name string, arguments interface{}, options ...SubmitOption) (*Job, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return q.SubmitContext(ctx, name, arguments, options...)
+}
+
+// SubmitContext queues a job, waiting until ctx is done for room in the queue.
+// It fails with ErrQueueFull when the queue is still full by then, in which
+// case the job is not stored.
+func (q *TaskQueue) SubmitContext(ctx context.Context, name string, arguments interface{}, options ...SubmitOption) (job *Job, err error) {
+	job, err = q.newJob(name, arguments, options...)
+	if err != nil {
+		return
+	}
+	if len(job.Dependencies) > 0 {
+		return q.submitWaiting(job)
+	}
+	if job.RunAt.After(job.Created) {
+		job.Status = JOB_SCHEDULED
+		stored, err := q.store(job)
+		if err != nil || stored != job {
+			return stored, err
+		}
+		q.dispatchAt(job, job.RunAt)
+		return job, nil
+	}
+
+	slots := q.tasks[job.Name].lane.slots
+	err = acquireSlot(ctx, slots)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := q.store(job)
+	if err != nil || stored != job {
+		<-slots
+		return stored, err
+	}
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
+	if q.cancelled[job.UUID] {
+		q.forget(job.UUID)
+		<-slots
+		return
+	}
+	q.enqueue(job, job.RunAt, true)
+	return
 }
 
-func (q *TaskQueue) Submit(name string, arguments interface{}) (job *Job, err error) {
+func (q *TaskQueue) newJob(name string, arguments interface{}, options ...SubmitOption) (job *Job, err error) {
+	select {
+	case <-q.closed:
+		err = ErrQueueClosed
+		return
+	default:
+	}
 	uuid, err := newUUID()
 	if err != nil {
 		return
 	}
 
-	if _, ok := q.tasks[name]; !ok {
+	t, ok := q.tasks[name]
+	if !ok {
 		err = errors.New("Unknown task: " + name)
 		return
 	}
-
 	now := time.Now()
 	job = &Job{
 		Name:      name,
+		Queue:     t.lane.name,
 		UUID:      uuid,
 		Status:    JOB_PENDING,
 		Arguments: arguments,
+		Timeout:   t.timeout,
+		RunAt:     now,
 		Created:   now,
 		Updated:   now,
 	}
-	err = q.jobStore.Store(job)
-	if err != nil {
-		return
+	for _, option := range options {
+		option(job)
+	}
+	// A job ranks by when it became due, so a due time in the past must not
+	// count as time spent waiting.
+	if job.RunAt.Before(now) {
+		job.RunAt = now
+	}
+	// Arguments that come from other jobs are only known when the job
+	// starts, so execute checks them then.
+	if job.ArgumentsFrom == "" && !job.validateOnRun {
+		err = t.validateArguments(name, arguments)
+		if err != nil {
+			return nil, err
+		}
 	}
-	q.jobQueue <- job
 	return
 }
 
+func acquireSlot(ctx context.Context, slots chan bool) error {
+	select {
+	case slots <- true:
+		return nil
+	default:
+	}
+	select {
+	case slots <- true:
+		return nil
+	case <-ctx.Done():
+		return ErrQueueFull
+	}
+}
+
+// SubmitAt submits a job that will not run before the given time.
+func (q *TaskQueue) SubmitAt(name string, arguments interface{}, at time.Time, options ...SubmitOption) (*Job, error) {
+	return q.Submit(name, arguments, append(options, runAt(at))...)
+}
+
+// SubmitAfter submits a job that will not run before delay has passed.
+func (q *TaskQueue) SubmitAfter(name string, arguments interface{}, delay time.Duration, options ...SubmitOption) (*Job, error) {
+	return q.SubmitAt(name, arguments, time.Now().Add(delay), options...)
+}
+
+func runAt(at time.Time) SubmitOption {
+	return func(job *Job) {
+		job.RunAt = at
+	}
+}
+
 func (q *TaskQueue) GetJobs() (jobs []*Job, err error) {
 	jobs, err = q.jobStore.GetJobs()
 	return
@@ -57,44 +262,351 @@ func (q *TaskQueue) GetJob(uuid string) (*Job, error) {
 	return q.jobStore.GetJob(uuid)
 }
 
+// Cancel stops a job. A pending job is removed from the queue and marked as
+// cancelled immediately, the context of a running job is cancelled and the
+// job is marked as cancelled once its runner returns.
+func (q *TaskQueue) Cancel(uuid string) (job *Job, err error) {
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
+
+	job, err = q.jobStore.GetJob(uuid)
+	if err != nil {
+		return
+	}
+	if cancel, ok := q.executions[uuid]; ok {
+		q.cancelled[uuid] = true
+		cancel()
+		return
+	}
+	if job.HasFinished() {
+		err = ErrJobFinished
+		return
+	}
+	if _, ok := q.waiting[uuid]; ok {
+		delete(q.waiting, uuid)
+	} else if !q.unqueue(uuid) {
+		q.cancelled[uuid] = true
+	}
+	err = q.finish(uuid, JOB_CANCELLED)
+	if err != nil {
+		return
+	}
+	return q.jobStore.GetJob(uuid)
+}
+
 func (q *TaskQueue) Start() (err error) {
 	err = q.jobStore.Start()
 	if err != nil {
 		return
 	}
-	go func() {
-		for {
-			select {
-			case job := <-q.jobQueue:
-				q.run(job)
-			case <-q.stopQueue:
-				return
-			}
+	err = q.recover()
+	if err != nil {
+		return
+	}
+	go q.dispatch()
+	err = q.startSchedules()
+	return
+}
+
+// recover queues the jobs that a previous run of the queue left behind in the
+// job store. Jobs that were interrupted while running are handled according
+// to the recovery policy.
+func (q *TaskQueue) recover() (err error) {
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
 
+	pending, err := q.jobStore.GetJobsByStatus(JOB_PENDING, JOB_RETRYING, JOB_SCHEDULED)
+	if err != nil {
+		return
+	}
+	interrupted, err := q.jobStore.GetJobsByStatus(JOB_RUNNING)
+	if err != nil {
+		return
+	}
+	waiting, err := q.jobStore.GetJobsByStatus(JOB_WAITING)
+	if err != nil {
+		return
+	}
+	for _, job := range waiting {
+		q.waiting[job.UUID] = job
+	}
+
+	for _, job := range interrupted {
+		if _, ok := q.tasks[job.Name]; !ok {
+			log.Println("Not recovering job " + job.UUID + " of unknown task " + job.Name)
+			continue
 		}
-	}()
+		if q.recovery == RECOVER_REQUEUE {
+			err = q.jobStore.SetStatus(job.UUID, JOB_PENDING, time.Now())
+			q.enqueue(job, job.RunAt, false)
+		} else {
+			err = q.jobStore.SetResult(job.UUID, "Job interrupted by a restart")
+			if err == nil {
+				err = q.finish(job.UUID, JOB_FAILURE)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	for _, job := range pending {
+		if _, ok := q.tasks[job.Name]; !ok {
+			log.Println("Not recovering job " + job.UUID + " of unknown task " + job.Name)
+			continue
+		}
+		if job.Status == JOB_SCHEDULED {
+			q.dispatchAt(job, job.RunAt)
+			continue
+		}
+		q.enqueue(job, job.RunAt, false)
+	}
+	for _, job := range q.waiting {
+		q.resolve(job)
+	}
+	batches, err := q.jobStore.GetUnsettledBatches()
+	if err != nil {
+		return
+	}
+	for _, batch := range batches {
+		q.completeBatch(batch)
+	}
 	return
 }
 
 func (q *TaskQueue) Stop() {
+	q.cancel()
 	q.jobStore.Stop()
 	q.stopQueue <- true
 }
 
-func (q *TaskQueue) run(job *Job) {
+// dispatch starts pending jobs as long as the workers of their queue are not
+// all busy.
+func (q *TaskQueue) dispatch() {
+	for {
+		for q.startNext() {
+		}
+		select {
+		case <-q.wakeup:
+		case job := <-q.finished:
+			q.jobMutex.Lock()
+			t := q.tasks[job.Name]
+			t.running--
+			t.lane.running--
+			q.jobMutex.Unlock()
+		case <-q.stopQueue:
+			return
+		}
+	}
+}
+
+// startNext runs the next pending job in a new goroutine. It reports false
+// when no job can be started.
+func (q *TaskQueue) startNext() bool {
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
+	job := q.dequeue()
+	if job == nil {
+		return false
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if job.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(q.ctx, job.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(q.ctx)
+	}
+	q.executions[job.UUID] = cancel
+	ctx = context.WithValue(ctx, reporterKey{}, &Reporter{q, job.UUID})
+	ctx = context.WithValue(ctx, loggerKey{}, &JobLogger{q, job.UUID})
+
+	t := q.tasks[job.Name]
+	t.running++
+	t.lane.running++
+	q.inFlight.Add(1)
+	go func() {
+		defer q.inFlight.Done()
+		q.run(ctx, job)
+		q.finished <- job
+	}()
+	return true
+}
+
+func (q *TaskQueue) run(ctx context.Context, job *Job) {
+	attempts := 1
+	var errs []string
+	if stored, err := q.jobStore.GetJob(job.UUID); err == nil {
+		attempts = stored.Attempts + 1
+		errs = append(errs, stored.Errors...)
+	}
+	q.jobStore.SetAttempts(job.UUID, attempts, errs)
 	q.jobStore.SetStatus(job.UUID, JOB_RUNNING, time.Now())
-	result, err := q.tasks[job.Name].Run(job.Arguments)
+	result, err := q.execute(ctx, job)
+	timedOut := ctx.Err() == context.DeadlineExceeded
+
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
+	if _, ok := q.executions[job.UUID]; !ok {
+		// Abandoned by Shutdown, which already recorded the status.
+		return
+	}
+	cancelled := q.cancelled[job.UUID]
+	q.forget(job.UUID)
+
 	q.jobStore.SetResult(job.UUID, result)
+	if cancelled {
+		if result == nil {
+			q.jobStore.SetResult(job.UUID, "Job cancelled")
+		}
+		q.finish(job.UUID, JOB_CANCELLED)
+		return
+	}
+	if timedOut {
+		if result == nil {
+			q.jobStore.SetResult(job.UUID, "Job timed out after "+job.Timeout.String())
+		}
+		q.finish(job.UUID, JOB_TIMEOUT)
+		return
+	}
+	if panicErr, ok := err.(*PanicError); ok {
+		q.panics++
+		log.Println("Job " + job.UUID + " " + panicErr.Error())
+		q.jobStore.SetAttempts(job.UUID, attempts, append(errs, panicErr.Error()))
+		q.jobStore.SetResult(job.UUID, panicErr.Error()+"\n"+string(panicErr.Stack))
+		q.finish(job.UUID, JOB_FAILURE)
+		return
+	}
 	if err != nil {
+		q.jobStore.SetAttempts(job.UUID, attempts, append(errs, err.Error()))
+		retry := q.tasks[job.Name].retry
+		_, invalid := err.(*ValidationError)
+		if retry != nil && !invalid && q.ctx.Err() == nil && retry.shouldRetry(attempts, err) {
+			q.jobStore.SetStatus(job.UUID, JOB_RETRYING, time.Now())
+			q.dispatchAt(job, time.Now().Add(retry.backoff(attempts)))
+			return
+		}
 		if result == nil {
 			q.jobStore.SetResult(job.UUID, err.Error())
 		}
-		q.jobStore.SetStatus(job.UUID, JOB_FAILURE, time.Now())
+		q.finish(job.UUID, JOB_FAILURE)
 		return
 	}
-	q.jobStore.SetStatus(job.UUID, JOB_SUCCESS, time.Now())
+	q.finish(job.UUID, JOB_SUCCESS)
+}
+
+// execute runs the task of job, with the result of another job as arguments
+// if it asks for that. It stops waiting for the runner once the
+// deadline of ctx has passed, so that a runner that ignores its context does
+// not keep a worker busy. A panic of the runner is returned as a
+// *PanicError.
+func (q *TaskQueue) execute(ctx context.Context, job *Job) (interface{}, error) {
+	type outcome struct {
+		result interface{}
+		err    error
+	}
+	arguments := job.Arguments
+	if job.ArgumentsFrom != "" {
+		parent, err := q.jobStore.GetJob(job.ArgumentsFrom)
+		if err != nil {
+			return nil, err
+		}
+		arguments = parent.Result
+	}
+	err := q.tasks[job.Name].validateArguments(job.Name, arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if value := recover(); value != nil {
+				done <- outcome{nil, &PanicError{value, debug.Stack()}}
+			}
+		}()
+		result, err := q.tasks[job.Name].runner.RunContext(ctx, arguments)
+		done <- outcome{result, err}
+	}()
+
+	var expired <-chan struct{}
+	if _, ok := ctx.Deadline(); ok {
+		expired = ctx.Done()
+	}
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-expired:
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ctx.Err()
+		}
+	}
+	o := <-done
+	return o.result, o.err
+}
+
+// dispatchAt hands a scheduled or retrying job back to the dispatcher once it
+// is due, unless it was cancelled in the meantime.
+func (q *TaskQueue) dispatchAt(job *Job, at time.Time) {
+	time.AfterFunc(time.Until(at), func() {
+		if q.ctx.Err() != nil {
+			return
+		}
+		q.jobMutex.Lock()
+		defer q.jobMutex.Unlock()
+		if q.cancelled[job.UUID] {
+			q.forget(job.UUID)
+			return
+		}
+		q.jobStore.SetStatus(job.UUID, JOB_PENDING, time.Now())
+		q.enqueue(job, at, false)
+	})
+}
+
+// finish stores the final status of a job, resolves the jobs that wait for
+// it and completes its batch. The caller must hold q.jobMutex.
+func (q *TaskQueue) finish(uuid string, status string) error {
+	err := q.jobStore.SetStatus(uuid, status, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, job := range q.waiting {
+		for _, dependency := range job.Dependencies {
+			if dependency == uuid {
+				q.resolve(job)
+				break
+			}
+		}
+	}
+	q.settleBatch(uuid)
+	return nil
+}
+
+// isRunning tells whether a job is running. Writes of a runner go to the
+// store after the lock is released, so they do not hold up the dispatcher.
+func (q *TaskQueue) isRunning(uuid string) bool {
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
+	_, ok := q.executions[uuid]
+	return ok
+}
+
+// forget releases the bookkeeping of a job that will not run anymore. The
+// caller must hold q.jobMutex.
+func (q *TaskQueue) forget(uuid string) {
+	if cancel, ok := q.executions[uuid]; ok {
+		cancel()
+		delete(q.executions, uuid)
+	}
+	delete(q.cancelled, uuid)
 }
 
 func (job *Job) HasFinished() bool {
-	return job.Status == JOB_SUCCESS || job.Status == JOB_FAILURE
+	return isFinal(job.Status)
+}
+
+func isFinal(status string) bool {
+	switch status {
+	case JOB_SUCCESS, JOB_FAILURE, JOB_CANCELLED, JOB_TIMEOUT, JOB_SKIPPED:
+		return true
+	}
+	return false
 }
@@ -1,7 +1,9 @@
 package tsq
 
 import (
+	"context"
 	"errors"
+	"strings"
 	"testing"
 	"time"
 )
@@ -84,9 +86,7 @@ func TestSuccess(t *testing.T) {
 	run := NewTestRun()
 	job, _ := tsq.Submit("test", run)
 	run.WaitForFinish(t)
-	if job.Status != JOB_SUCCESS {
-		t.Fail()
-	}
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
 }
 
 func TestFailure(t *testing.T) {
@@ -95,9 +95,7 @@ func TestFailure(t *testing.T) {
 	run.shouldFail = true
 	job, _ := tsq.Submit("test", run)
 	run.WaitForFinish(t)
-	if job.Status != JOB_FAILURE {
-		t.Fail()
-	}
+	WaitForStatus(t, tsq, job.UUID, JOB_FAILURE)
 }
 
 func TestRunning(t *testing.T) {
@@ -106,6 +104,7 @@ func TestRunning(t *testing.T) {
 	run.shouldWait = true
 	job, _ := tsq.Submit("test", run)
 	run.WaitForStart(t)
+	job, _ = tsq.GetJob(job.UUID)
 	if job.Status != JOB_RUNNING {
 		t.Error("failed")
 	}
@@ -116,6 +115,8 @@ func TestReturnValue(t *testing.T) {
 	run := NewTestRun()
 	job, _ := tsq.Submit("test", run)
 	run.WaitForFinish(t)
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
+	job, _ = tsq.GetJob(job.UUID)
 	if job.Result.(string) != "DATA" {
 		t.Fail()
 	}
@@ -129,6 +130,7 @@ func TestJobsAreSerialized(t *testing.T) {
 	tsq.Submit("test", run1)
 	job2, _ := tsq.Submit("test", run2)
 	run1.WaitForStart(t)
+	job2, _ = tsq.GetJob(job2.UUID)
 	if job2.Status != JOB_PENDING {
 		t.Fail()
 	}
@@ -142,11 +144,30 @@ func TestJobsAreStored(t *testing.T) {
 	job, _ := tsq.Submit("test", run)
 	run.WaitForFinish(t)
 	res, _ := tsq.GetJob(job.UUID)
-	if res != job {
+	if res.UUID != job.UUID || res.Name != job.Name {
 		t.Fail()
 	}
 }
 
+func TestQueuesDoNotShareStore(t *testing.T) {
+	tsq1 := NewTestQueue()
+	run := NewTestRun()
+	run.shouldWait = true
+	job, _ := tsq1.Submit("test", run)
+	run.WaitForStart(t)
+
+	tsq2 := NewTestQueue()
+	if _, err := tsq2.GetJob(job.UUID); err == nil {
+		t.Error("job of another queue was visible")
+	}
+	job, _ = tsq1.GetJob(job.UUID)
+	if job.Status != JOB_RUNNING {
+		t.Error("job was recovered by another queue:", job.Status)
+	}
+	run.forward <- true
+	WaitForStatus(t, tsq1, job.UUID, JOB_SUCCESS)
+}
+
 func TestGetUnknownJob(t *testing.T) {
 	tsq := NewTestQueue()
 	run := NewTestRun()
@@ -179,3 +200,1110 @@ func TestStoreFailure(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func NewTestQueueWithConfig(qConfig Config, options ...TaskOption) (tsq *TaskQueue) {
+	qConfig.JobStore = NewMemoryStore()
+	tsq = qConfig.NewQueue()
+	tsq.Define("test", &TestTask{}, options...)
+	tsq.Start()
+	return
+}
+
+func TestWorkersRunConcurrently(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{Workers: 2})
+	run1 := NewTestRun()
+	run1.shouldWait = true
+	run2 := NewTestRun()
+	run2.shouldWait = true
+	tsq.Submit("test", run1)
+	tsq.Submit("test", run2)
+	run1.WaitForStart(t)
+	run2.WaitForStart(t)
+	run1.forward <- true
+	run2.forward <- true
+	run1.WaitForFinish(t)
+	run2.WaitForFinish(t)
+}
+
+func TestTaskConcurrencyLimit(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{Workers: 2}, MaxConcurrency(1))
+	run1 := NewTestRun()
+	run1.shouldWait = true
+	run2 := NewTestRun()
+	tsq.Submit("test", run1)
+	job2, _ := tsq.Submit("test", run2)
+	run1.WaitForStart(t)
+	select {
+	case <-run2.started:
+		t.Fatal("job started while task was at its concurrency limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if job2.Status != JOB_PENDING {
+		t.Fail()
+	}
+	run1.forward <- true
+	run2.WaitForFinish(t)
+}
+
+type BlockingTask struct {
+	started chan bool
+}
+
+func (tsk *BlockingTask) RunContext(ctx context.Context, args interface{}) (data interface{}, err error) {
+	tsk.started <- true
+	<-ctx.Done()
+	err = ctx.Err()
+	return
+}
+
+func TestContextRunnerIsCancelledOnStop(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsk := &BlockingTask{started: make(chan bool, 1)}
+	tsq.DefineContext("block", tsk)
+	job, _ := tsq.Submit("block", nil)
+	select {
+	case <-tsk.started:
+	case <-time.After(1 * time.Second):
+		t.Fatal("wait for start timeout")
+	}
+	tsq.Stop()
+	WaitForStatus(t, tsq, job.UUID, JOB_FAILURE)
+	job, _ = tsq.GetJob(job.UUID)
+	if job.Status != JOB_FAILURE || job.Result != context.Canceled.Error() {
+		t.Error("job was not cancelled:", job.Status, job.Result)
+	}
+}
+
+func TestAdaptRunner(t *testing.T) {
+	run := NewTestRun()
+	data, err := AdaptRunner(&TestTask{}).RunContext(context.Background(), run)
+	if err != nil || data != "DATA" {
+		t.Fail()
+	}
+	cmd := &CommandTask{Cmd: "true"}
+	if AdaptRunner(cmd) != ContextRunner(cmd) {
+		t.Error("ContextRunner was wrapped")
+	}
+}
+
+func WaitForStatus(t *testing.T, tsq *TaskQueue, uuid string, status string) {
+	for i := 0; i < 100; i++ {
+		job, _ := tsq.GetJob(uuid)
+		if job.Status == status {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	job, _ := tsq.GetJob(uuid)
+	t.Errorf("job status %v, expected %v", job.Status, status)
+}
+
+func TestCancelPendingJob(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	run1 := NewTestRun()
+	run1.shouldWait = true
+	run2 := NewTestRun()
+	tsq.Submit("test", run1)
+	job2, _ := tsq.Submit("test", run2)
+	run1.WaitForStart(t)
+	job, err := tsq.Cancel(job2.UUID)
+	if err != nil || job.Status != JOB_CANCELLED {
+		t.Error("job was not cancelled", err)
+	}
+	run1.forward <- true
+	run1.WaitForFinish(t)
+	select {
+	case <-run2.started:
+		t.Error("cancelled job started")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsk := &BlockingTask{started: make(chan bool, 1)}
+	tsq.DefineContext("block", tsk)
+	job, _ := tsq.Submit("block", nil)
+	<-tsk.started
+	_, err := tsq.Cancel(job.UUID)
+	if err != nil {
+		t.Error(err)
+	}
+	WaitForStatus(t, tsq, job.UUID, JOB_CANCELLED)
+}
+
+func TestCancelFinishedJob(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	run := NewTestRun()
+	job, _ := tsq.Submit("test", run)
+	run.WaitForFinish(t)
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
+	_, err := tsq.Cancel(job.UUID)
+	if err != ErrJobFinished {
+		t.Error("expected ErrJobFinished, got", err)
+	}
+}
+
+type FlakyTask struct {
+	failures int
+	runs     int
+}
+
+func (tsk *FlakyTask) Run(args interface{}) (data interface{}, err error) {
+	tsk.runs++
+	if tsk.runs <= tsk.failures {
+		err = errors.New("FLAKY")
+		return
+	}
+	data = "DATA"
+	return
+}
+
+func TestRetrySucceeds(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("flaky", &FlakyTask{failures: 2}, Retry(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}))
+	job, _ := tsq.Submit("flaky", nil)
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
+	job, _ = tsq.GetJob(job.UUID)
+	if job.Attempts != 3 || len(job.Errors) != 2 || job.Errors[0] != "FLAKY" {
+		t.Error("unexpected attempts", job.Attempts, job.Errors)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("flaky", &FlakyTask{failures: 5}, Retry(RetryPolicy{MaxAttempts: 2}))
+	job, _ := tsq.Submit("flaky", nil)
+	WaitForStatus(t, tsq, job.UUID, JOB_FAILURE)
+	job, _ = tsq.GetJob(job.UUID)
+	if job.Attempts != 2 || len(job.Errors) != 2 {
+		t.Error("unexpected attempts", job.Attempts, job.Errors)
+	}
+}
+
+func TestRetryOnlyRetryableErrors(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	policy := RetryPolicy{
+		MaxAttempts: 3,
+		Retryable:   func(err error) bool { return err.Error() != "FLAKY" },
+	}
+	tsq.Define("flaky", &FlakyTask{failures: 1}, Retry(policy))
+	job, _ := tsq.Submit("flaky", nil)
+	WaitForStatus(t, tsq, job.UUID, JOB_FAILURE)
+	job, _ = tsq.GetJob(job.UUID)
+	if job.Attempts != 1 {
+		t.Error("non-retryable error was retried")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	if policy.backoff(1) != time.Second || policy.backoff(2) != 2*time.Second || policy.backoff(4) != 5*time.Second {
+		t.Error("unexpected backoff")
+	}
+	policy.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		if backoff := policy.backoff(1); backoff < time.Second/2 || backoff > 3*time.Second/2 {
+			t.Error("jitter out of range", backoff)
+		}
+	}
+}
+
+func TestTaskTimeout(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.DefineContext("block", &BlockingTask{started: make(chan bool, 1)}, Timeout(20*time.Millisecond))
+	job, _ := tsq.Submit("block", nil)
+	WaitForStatus(t, tsq, job.UUID, JOB_TIMEOUT)
+}
+
+func TestExecutionTimeoutOverride(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("sleep", &CommandTask{Cmd: "sleep", Args: []string{"5"}}, Timeout(time.Hour))
+	job, _ := tsq.Submit("sleep", nil, ExecutionTimeout(20*time.Millisecond))
+	WaitForStatus(t, tsq, job.UUID, JOB_TIMEOUT)
+}
+
+func TestTimeoutReleasesWorker(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{}, Timeout(20*time.Millisecond))
+	run1 := NewTestRun()
+	run1.shouldWait = true
+	job1, _ := tsq.Submit("test", run1)
+	run2 := NewTestRun()
+	tsq.Submit("test", run2)
+	run2.WaitForFinish(t)
+	WaitForStatus(t, tsq, job1.UUID, JOB_TIMEOUT)
+	run1.forward <- true
+}
+
+func StoreTestJob(store JobStore, status string, run *TestRun) *Job {
+	uuid, _ := newUUID()
+	job := &Job{UUID: uuid, Name: "test", Status: status, Arguments: run, Created: time.Now()}
+	store.Store(job)
+	return job
+}
+
+func TestRecoverPendingJobs(t *testing.T) {
+	store := NewMemoryStore()
+	run := NewTestRun()
+	job := StoreTestJob(store, JOB_PENDING, run)
+	tsq := (&Config{JobStore: store}).NewQueue()
+	DefineTestTask(tsq)
+	tsq.Start()
+	run.WaitForFinish(t)
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
+}
+
+func TestRecoverInterruptedJobsFail(t *testing.T) {
+	store := NewMemoryStore()
+	job := StoreTestJob(store, JOB_RUNNING, NewTestRun())
+	tsq := (&Config{JobStore: store}).NewQueue()
+	DefineTestTask(tsq)
+	tsq.Start()
+	job, _ = tsq.GetJob(job.UUID)
+	if job.Status != JOB_FAILURE {
+		t.Error("interrupted job was not failed:", job.Status)
+	}
+}
+
+func TestRecoverInterruptedJobsRequeue(t *testing.T) {
+	store := NewMemoryStore()
+	run := NewTestRun()
+	job := StoreTestJob(store, JOB_RUNNING, run)
+	tsq := (&Config{JobStore: store, RecoveryPolicy: RECOVER_REQUEUE}).NewQueue()
+	DefineTestTask(tsq)
+	tsq.Start()
+	run.WaitForFinish(t)
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
+}
+
+func TestSubmitAfter(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	run := NewTestRun()
+	job, _ := tsq.SubmitAfter("test", run, 50*time.Millisecond)
+	if job.Status != JOB_SCHEDULED {
+		t.Error("job was not scheduled:", job.Status)
+	}
+	select {
+	case <-run.started:
+		t.Error("job started before it was due")
+	case <-time.After(20 * time.Millisecond):
+	}
+	run.WaitForFinish(t)
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
+}
+
+func TestCancelScheduledJob(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	run := NewTestRun()
+	job, _ := tsq.SubmitAfter("test", run, 20*time.Millisecond)
+	tsq.Cancel(job.UUID)
+	select {
+	case <-run.started:
+		t.Error("cancelled job started")
+	case <-time.After(50 * time.Millisecond):
+	}
+	WaitForStatus(t, tsq, job.UUID, JOB_CANCELLED)
+}
+
+func TestRecoverScheduledJobs(t *testing.T) {
+	store := NewMemoryStore()
+	run := NewTestRun()
+	job := StoreTestJob(store, JOB_SCHEDULED, run)
+	job.RunAt = time.Now().Add(20 * time.Millisecond)
+	tsq := (&Config{JobStore: store}).NewQueue()
+	DefineTestTask(tsq)
+	tsq.Start()
+	run.WaitForFinish(t)
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
+}
+
+func TestScheduleSkipsOverlappingRuns(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsk := &BlockingTask{started: make(chan bool, 1)}
+	tsq.DefineContext("block", tsk)
+	err := tsq.Schedule("nightly", "block", "@daily", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule := tsq.schedules["nightly"]
+	tsq.trigger(schedule)
+	<-tsk.started
+	first := tsq.GetSchedules()[0]
+	if first.LastJob == "" || first.NextRun.Before(time.Now()) {
+		t.Error("schedule did not run", first)
+	}
+
+	tsq.trigger(schedule)
+	if tsq.GetSchedules()[0].LastJob != first.LastJob {
+		t.Error("schedule ran while its previous job was running")
+	}
+
+	tsq.Cancel(first.LastJob)
+	WaitForStatus(t, tsq, first.LastJob, JOB_CANCELLED)
+	tsq.trigger(schedule)
+	if tsq.GetSchedules()[0].LastJob == first.LastJob {
+		t.Error("schedule did not run after its previous job finished")
+	}
+}
+
+func TestScheduleRestoresState(t *testing.T) {
+	store := NewMemoryStore()
+	next := time.Now().Add(time.Hour).Truncate(time.Minute)
+	store.StoreSchedule(&Schedule{Name: "hourly", Task: "test", Spec: "@hourly", LastJob: "previous", NextRun: next})
+	tsq := (&Config{JobStore: store}).NewQueue()
+	DefineTestTask(tsq)
+	tsq.Schedule("hourly", "test", "@hourly", nil)
+	tsq.Start()
+	schedule := tsq.GetSchedules()[0]
+	if schedule.LastJob != "previous" || !schedule.NextRun.Equal(next) {
+		t.Error("schedule state was not restored", schedule)
+	}
+	if tsq.Schedule("hourly", "test", "@daily", nil) == nil {
+		t.Error("duplicate schedule was accepted")
+	}
+}
+
+type OrderTask struct {
+	order chan string
+}
+
+func (tsk *OrderTask) Run(args interface{}) (data interface{}, err error) {
+	tsk.order <- args.(string)
+	return
+}
+
+func ExpectOrder(t *testing.T, order chan string, expected ...string) {
+	for _, name := range expected {
+		select {
+		case actual := <-order:
+			if actual != name {
+				t.Error("expected", name, "got", actual)
+			}
+		case <-time.After(1 * time.Second):
+			t.Error("wait for", name, "timeout")
+		}
+	}
+}
+
+func TestPriority(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsk := &OrderTask{order: make(chan string, 3)}
+	tsq.Define("order", tsk)
+	run := NewTestRun()
+	run.shouldWait = true
+	tsq.Submit("test", run)
+	run.WaitForStart(t)
+	tsq.Submit("order", "low")
+	tsq.Submit("order", "high", Priority(1))
+	tsq.Submit("order", "lowest", Priority(-1))
+	run.forward <- true
+	ExpectOrder(t, tsk.order, "high", "low", "lowest")
+}
+
+func TestPastRunAtDoesNotOutrankPriority(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsk := &OrderTask{order: make(chan string, 2)}
+	tsq.Define("order", tsk)
+	run := NewTestRun()
+	run.shouldWait = true
+	tsq.Submit("test", run)
+	run.WaitForStart(t)
+	tsq.SubmitAt("order", "past", time.Unix(0, 0))
+	tsq.Submit("order", "high", Priority(100))
+	run.forward <- true
+	ExpectOrder(t, tsk.order, "high", "past")
+}
+
+func TestPriorityAging(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{PriorityAging: time.Millisecond})
+	tsk := &OrderTask{order: make(chan string, 2)}
+	tsq.Define("order", tsk)
+	run := NewTestRun()
+	run.shouldWait = true
+	tsq.Submit("test", run)
+	run.WaitForStart(t)
+	tsq.Submit("order", "low")
+	time.Sleep(20 * time.Millisecond)
+	tsq.Submit("order", "high", Priority(10))
+	run.forward <- true
+	ExpectOrder(t, tsk.order, "low", "high")
+}
+
+func TestSubmitQueueFull(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{QueueLength: 1})
+	run1 := NewTestRun()
+	run1.shouldWait = true
+	tsq.Submit("test", run1)
+	run1.WaitForStart(t)
+	tsq.Submit("test", NewTestRun())
+	_, err := tsq.Submit("test", NewTestRun())
+	if err != ErrQueueFull {
+		t.Error("expected ErrQueueFull, got", err)
+	}
+	jobs, _ := tsq.GetJobs()
+	if len(jobs) != 2 {
+		t.Error("rejected job was stored")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	run3 := NewTestRun()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		run1.forward <- true
+	}()
+	_, err = tsq.SubmitContext(ctx, "test", run3)
+	if err != nil {
+		t.Error(err)
+	}
+	run3.WaitForFinish(t)
+}
+
+func TestWorkflow(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{Workers: 2})
+	tsk := &OrderTask{order: make(chan string, 4)}
+	tsq.Define("order", tsk)
+	wf, _ := tsq.NewWorkflow()
+	build, _ := wf.Submit("order", "build")
+	test, _ := wf.Submit("order", "test", DependsOn(build.UUID))
+	lint, _ := wf.Submit("order", "lint", DependsOn(build.UUID))
+	deploy, _ := wf.Submit("order", "deploy", DependsOn(test.UUID, lint.UUID))
+	if deploy.Status != JOB_WAITING {
+		t.Error("job with dependencies is not waiting:", deploy.Status)
+	}
+	ExpectOrder(t, tsk.order, "build")
+	<-tsk.order
+	<-tsk.order
+	ExpectOrder(t, tsk.order, "deploy")
+	WaitForStatus(t, tsq, deploy.UUID, JOB_SUCCESS)
+
+	jobs, _ := tsq.GetWorkflow(wf.ID)
+	if len(jobs) != 4 {
+		t.Error("unexpected workflow jobs", jobs)
+	}
+}
+
+func TestWorkflowSkipsAfterFailure(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	wf, _ := tsq.NewWorkflow()
+	run := NewTestRun()
+	run.shouldFail = true
+	build, _ := wf.Submit("test", run)
+	test, _ := wf.Submit("test", NewTestRun(), DependsOn(build.UUID))
+	deploy, _ := wf.Submit("test", NewTestRun(), DependsOn(test.UUID))
+	WaitForStatus(t, tsq, test.UUID, JOB_SKIPPED)
+	WaitForStatus(t, tsq, deploy.UUID, JOB_SKIPPED)
+
+	late, _ := wf.Submit("test", NewTestRun(), DependsOn(build.UUID))
+	if late.Status != JOB_SKIPPED {
+		t.Error("job depending on a failed job was not skipped:", late.Status)
+	}
+	_, err := wf.Submit("test", NewTestRun(), DependsOn("unknown"))
+	if err == nil {
+		t.Error("unknown dependency was accepted")
+	}
+}
+
+func TestRecoverWaitingJobs(t *testing.T) {
+	store := NewMemoryStore()
+	parent := StoreTestJob(store, JOB_SUCCESS, nil)
+	run := NewTestRun()
+	child := StoreTestJob(store, JOB_WAITING, run)
+	child.Dependencies = []string{parent.UUID}
+	tsq := (&Config{JobStore: store}).NewQueue()
+	DefineTestTask(tsq)
+	tsq.Start()
+	run.WaitForFinish(t)
+	WaitForStatus(t, tsq, child.UUID, JOB_SUCCESS)
+}
+
+type AddTask struct{}
+
+func (tsk *AddTask) Run(args interface{}) (data interface{}, err error) {
+	n, ok := args.(float64)
+	if !ok {
+		err = errors.New("number required")
+		return
+	}
+	data = n + 1
+	return
+}
+
+func TestChain(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("add", &AddTask{})
+	chain, err := tsq.SubmitChain([]string{"add", "add", "add"}, 1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := chain.Jobs[2]
+	WaitForStatus(t, tsq, last.UUID, JOB_SUCCESS)
+	chain, _ = tsq.GetChain(chain.ID)
+	if chain.Status != JOB_SUCCESS || chain.Jobs[2].Result != 4.0 {
+		t.Error("unexpected chain", chain.Status, chain.Jobs[2].Result)
+	}
+}
+
+func TestChainStopsAtFailure(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("add", &AddTask{})
+	chain, _ := tsq.SubmitChain([]string{"add", "add", "add"}, "one")
+	WaitForStatus(t, tsq, chain.Jobs[2].UUID, JOB_SKIPPED)
+	chain, _ = tsq.GetChain(chain.ID)
+	if chain.Status != JOB_FAILURE || chain.Jobs[1].Status != JOB_SKIPPED {
+		t.Error("unexpected chain status", chain.Status)
+	}
+
+	before, _ := tsq.GetJobs()
+	_, err := tsq.SubmitChain([]string{"add", "unknown"}, 1.0)
+	if err == nil {
+		t.Error("chain with unknown task was accepted")
+	}
+	if after, _ := tsq.GetJobs(); len(after) != len(before) {
+		t.Error("part of a rejected chain was stored")
+	}
+}
+
+// CancelOnStoreStore cancels the first job of a chain as soon as it is stored.
+type CancelOnStoreStore struct {
+	JobStore
+	queue *TaskQueue
+}
+
+func (s *CancelOnStoreStore) StoreJobs(jobs []*Job) error {
+	err := s.JobStore.StoreJobs(jobs)
+	if err == nil {
+		s.queue.Cancel(jobs[0].UUID)
+	}
+	return err
+}
+
+func TestChainCancelledWhileSubmitted(t *testing.T) {
+	store := &CancelOnStoreStore{JobStore: NewMemoryStore()}
+	tsq := (&Config{JobStore: store}).NewQueue()
+	store.queue = tsq
+	collect := &CollectTask{make(chan interface{}, 1)}
+	tsq.Define("collect", collect)
+	tsq.Define("add", &AddTask{})
+	tsq.Start()
+	chain, err := tsq.SubmitChain([]string{"collect", "add", "add"}, 1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	WaitForStatus(t, tsq, chain.Jobs[2].UUID, JOB_SKIPPED)
+	select {
+	case <-collect.results:
+		t.Error("cancelled chain was run")
+	case <-time.After(20 * time.Millisecond):
+	}
+	chain, _ = tsq.GetChain(chain.ID)
+	if chain.Jobs[0].Status != JOB_CANCELLED || chain.Jobs[1].Status != JOB_SKIPPED {
+		t.Error("unexpected status of cancelled chain", chain.Jobs[0].Status, chain.Jobs[1].Status)
+	}
+}
+
+type CollectTask struct {
+	results chan interface{}
+}
+
+func (tsk *CollectTask) Run(args interface{}) (data interface{}, err error) {
+	tsk.results <- args
+	return
+}
+
+func TestBatch(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{Workers: 2})
+	tsq.Define("add", &AddTask{})
+	collect := &CollectTask{make(chan interface{}, 1)}
+	tsq.Define("collect", collect)
+	batch, err := tsq.SubmitBatch([]BatchJob{{"add", 1.0}, {"add", 2.0}, {"add", "three"}}, "collect")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var results []BatchResult
+	select {
+	case args := <-collect.results:
+		results = args.([]BatchResult)
+	case <-time.After(time.Second):
+		t.Fatal("batch callback was not called")
+	}
+	if len(results) != 3 || results[0].Result != 2.0 || results[2].Status != JOB_FAILURE {
+		t.Error("unexpected batch results", results)
+	}
+
+	batch, _ = tsq.GetBatch(batch.ID)
+	if batch.Counts[JOB_SUCCESS] != 2 || batch.Counts[JOB_FAILURE] != 1 || batch.CallbackJob == "" {
+		t.Error("unexpected batch", batch.Counts, batch.CallbackJob)
+	}
+
+	_, err = tsq.SubmitBatch([]BatchJob{{"add", 1.0}}, "unknown")
+	if err == nil {
+		t.Error("batch with unknown callback was accepted")
+	}
+}
+
+func TestRecoverUnsettledBatch(t *testing.T) {
+	store := NewMemoryStore()
+	tsq := (&Config{JobStore: store}).NewQueue()
+	DefineTestTask(tsq)
+	tsq.Define("collect", &CollectTask{make(chan interface{}, 1)})
+	tsq.Start()
+	run := NewTestRun()
+	run.shouldWait = true
+	batch, err := tsq.SubmitBatch([]BatchJob{{"test", run}}, "collect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run.WaitForStart(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tsq.Shutdown(ctx)
+	run.forward <- true
+	if stored, _ := store.GetBatch(batch.ID); stored.CallbackJob != "" {
+		t.Fatal("callback was submitted to a closed queue")
+	}
+
+	tsq = (&Config{JobStore: store}).NewQueue()
+	DefineTestTask(tsq)
+	collect := &CollectTask{make(chan interface{}, 1)}
+	tsq.Define("collect", collect)
+	tsq.Start()
+	select {
+	case <-collect.results:
+	case <-time.After(time.Second):
+		t.Fatal("batch callback was not recovered")
+	}
+}
+
+func TestUniqueJobs(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsk := &BlockingTask{started: make(chan bool, 1)}
+	tsq.DefineContext("block", tsk)
+	first, _ := tsq.Submit("block", "a", Unique(0))
+	second, _ := tsq.Submit("block", "a", Unique(0))
+	other, _ := tsq.Submit("block", "b", Unique(0))
+	if second.UUID != first.UUID || other.UUID == first.UUID {
+		t.Error("unexpected deduplication", first.UUID, second.UUID, other.UUID)
+	}
+
+	tsq.Cancel(first.UUID)
+	third, _ := tsq.Submit("block", "a", Unique(0))
+	if third.UUID == first.UUID {
+		t.Error("finished job still holds its key")
+	}
+}
+
+func TestUniqueKeyWindow(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("add", &AddTask{})
+	first, _ := tsq.Submit("add", 1.0, UniqueKey("once", time.Minute))
+	WaitForStatus(t, tsq, first.UUID, JOB_SUCCESS)
+	second, _ := tsq.Submit("add", 2.0, UniqueKey("once", time.Minute))
+	if second.UUID != first.UUID {
+		t.Error("key was released before the end of its window")
+	}
+}
+
+func TestTaskRateLimit(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{Workers: 2})
+	tsq.Define("add", &AddTask{}, RateLimit(300*time.Millisecond, 2))
+	first, _ := tsq.Submit("add", 1.0)
+	second, _ := tsq.Submit("add", 2.0)
+	third, _ := tsq.Submit("add", 3.0)
+	WaitForStatus(t, tsq, first.UUID, JOB_SUCCESS)
+	WaitForStatus(t, tsq, second.UUID, JOB_SUCCESS)
+	job, _ := tsq.GetJob(third.UUID)
+	if job.Status != JOB_PENDING {
+		t.Error("job over the rate limit was not kept pending", job.Status)
+	}
+
+	var info TaskInfo
+	for _, task := range tsq.GetTasks() {
+		if task.Name == "add" {
+			info = task
+		}
+	}
+	if info.RateLimit == nil || info.RateLimit.Burst != 2 || info.RateLimit.Tokens >= 1 || info.Pending != 1 {
+		t.Error("unexpected task info", info, info.RateLimit)
+	}
+	WaitForStatus(t, tsq, third.UUID, JOB_SUCCESS)
+}
+
+func TestPauseQueue(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("add", &AddTask{})
+	tsq.Pause()
+	job, err := tsq.Submit("add", 1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	job, _ = tsq.GetJob(job.UUID)
+	if job.Status != JOB_PENDING || !tsq.IsPaused() {
+		t.Error("job started while the queue was paused", job.Status)
+	}
+	tsq.Resume()
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
+}
+
+func TestPauseTask(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("add", &AddTask{})
+	tsq.PauseTask("add")
+	paused, _ := tsq.Submit("add", 1.0)
+	run := NewTestRun()
+	tsq.Submit("test", run)
+	run.WaitForFinish(t)
+	job, _ := tsq.GetJob(paused.UUID)
+	if job.Status != JOB_PENDING {
+		t.Error("job of a paused task started", job.Status)
+	}
+	tsq.ResumeTask("add")
+	WaitForStatus(t, tsq, paused.UUID, JOB_SUCCESS)
+
+	if tsq.PauseTask("unknown") == nil {
+		t.Error("paused an unknown task")
+	}
+}
+
+func TestShutdownDrainsRunningJobs(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	run1 := NewTestRun()
+	run1.shouldWait = true
+	running, _ := tsq.Submit("test", run1)
+	pending, _ := tsq.Submit("test", NewTestRun())
+	run1.WaitForStart(t)
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		done <- tsq.Shutdown(ctx)
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := tsq.Submit("test", NewTestRun()); err == ErrQueueClosed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	run1.forward <- true
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	job, _ := tsq.GetJob(running.UUID)
+	if job.Status != JOB_SUCCESS {
+		t.Error("running job was not drained", job.Status)
+	}
+	job, _ = tsq.GetJob(pending.UUID)
+	if job.Status != JOB_PENDING {
+		t.Error("pending job was started during shutdown", job.Status)
+	}
+}
+
+func TestResumeDuringShutdown(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	run1 := NewTestRun()
+	run1.shouldWait = true
+	tsq.Submit("test", run1)
+	run2 := NewTestRun()
+	pending, _ := tsq.Submit("test", run2)
+	run1.WaitForStart(t)
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		done <- tsq.Shutdown(ctx)
+	}()
+	for i := 0; i < 100 && !tsq.IsPaused(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	tsq.Resume()
+	tsq.ResumeTask("test")
+	select {
+	case <-run2.started:
+		t.Error("pending job was started after resuming a closing queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !tsq.IsPaused() {
+		t.Error("closing queue was resumed")
+	}
+	run1.forward <- true
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	job, _ := tsq.GetJob(pending.UUID)
+	if job.Status != JOB_PENDING {
+		t.Error("pending job was started during shutdown", job.Status)
+	}
+}
+
+func TestShutdownCancelsJobsAtDeadline(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	run := NewTestRun()
+	run.shouldWait = true
+	job, _ := tsq.Submit("test", run)
+	run.WaitForStart(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := tsq.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Error("unexpected shutdown error", err)
+	}
+	job, _ = tsq.GetJob(job.UUID)
+	if job.Status != JOB_CANCELLED {
+		t.Error("unexpected status of an interrupted job", job.Status)
+	}
+	run.forward <- true
+}
+
+type PanicTask struct{}
+
+func (tsk *PanicTask) Run(args interface{}) (interface{}, error) {
+	panic("broken task")
+}
+
+func TestPanicRecovery(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("panic", &PanicTask{}, Retry(RetryPolicy{MaxAttempts: 3}))
+	job, _ := tsq.Submit("panic", nil)
+	WaitForStatus(t, tsq, job.UUID, JOB_FAILURE)
+	job, _ = tsq.GetJob(job.UUID)
+	result, _ := job.Result.(string)
+	if !strings.HasPrefix(result, "panic: broken task") || !strings.Contains(result, "PanicTask") || job.Attempts != 1 {
+		t.Error("unexpected result of a panicked job", job.Attempts, result)
+	}
+	if tsq.Panics() != 1 {
+		t.Error("unexpected panic count", tsq.Panics())
+	}
+
+	run := NewTestRun()
+	tsq.Submit("test", run)
+	run.WaitForFinish(t)
+}
+
+func TestNamedQueues(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{Queues: map[string]QueueConfig{"bulk": {Workers: 1}}})
+	tsk := &BlockingTask{started: make(chan bool, 1)}
+	tsq.DefineContext("block", tsk, InQueue("bulk"))
+	blocked, _ := tsq.Submit("block", nil)
+	<-tsk.started
+	waiting, _ := tsq.Submit("block", nil)
+
+	run := NewTestRun()
+	job, _ := tsq.Submit("test", run)
+	run.WaitForFinish(t)
+	if job.Queue != DefaultQueue || blocked.Queue != "bulk" {
+		t.Error("unexpected queues", job.Queue, blocked.Queue)
+	}
+	WaitForStatus(t, tsq, waiting.UUID, JOB_PENDING)
+
+	var bulk QueueInfo
+	for _, queue := range tsq.GetQueues() {
+		if queue.Name == "bulk" {
+			bulk = queue
+		}
+	}
+	if bulk.Workers != 1 || bulk.Running != 1 || bulk.Pending != 1 {
+		t.Error("unexpected queue info", bulk)
+	}
+	tsq.Cancel(blocked.UUID)
+	WaitForStatus(t, tsq, waiting.UUID, JOB_RUNNING)
+}
+
+type Rectangle struct {
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+type Area struct {
+	Area float64 `json:"area"`
+}
+
+func area(ctx context.Context, r Rectangle) (Area, error) {
+	return Area{r.Width * r.Height}, nil
+}
+
+func TestDefineTyped(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	DefineTyped(tsq, "area", area)
+	job, err := tsq.Submit("area", map[string]interface{}{"width": 2.0, "height": 3.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
+	job, _ = tsq.GetJob(job.UUID)
+	result, ok := job.Result.(map[string]interface{})
+	if !ok || result["area"] != 6.0 {
+		t.Error("unexpected result", job.Result)
+	}
+
+	_, err = tsq.Submit("area", "square")
+	if _, ok := err.(*ValidationError); !ok {
+		t.Error("invalid arguments were accepted", err)
+	}
+}
+
+func TestArgumentSchema(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("add", &AddTask{}, ArgumentSchema(&Schema{Type: "number"}))
+	if _, err := tsq.Submit("add", 1.0); err != nil {
+		t.Error(err)
+	}
+	_, err := tsq.Submit("add", "one")
+	validation, ok := err.(*ValidationError)
+	if !ok || len(validation.Errors) != 1 {
+		t.Error("invalid arguments were accepted", err)
+	}
+	jobs, _ := tsq.GetJobs()
+	if len(jobs) != 1 {
+		t.Error("invalid job was stored")
+	}
+}
+
+type Counter struct {
+	N int `json:"n"`
+}
+
+func increment(ctx context.Context, c Counter) (Counter, error) {
+	return Counter{c.N + 1}, nil
+}
+
+func TestChainOfTypedTasks(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	DefineTyped(tsq, "inc", increment)
+	chain, err := tsq.SubmitChain([]string{"inc", "inc"}, map[string]interface{}{"n": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	WaitForStatus(t, tsq, chain.Jobs[1].UUID, JOB_SUCCESS)
+	job, _ := tsq.GetJob(chain.Jobs[1].UUID)
+	result, ok := job.Result.(map[string]interface{})
+	if !ok || result["n"] != 3.0 {
+		t.Error("unexpected result", job.Result)
+	}
+}
+
+func TestDeferredArgumentsAreValidated(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("add", &AddTask{})
+	tsq.Define("count", &AddTask{}, ArgumentSchema(&Schema{Type: "number"}))
+	collect := &CollectTask{make(chan interface{}, 1)}
+	tsq.Define("collect", collect, ArgumentSchema(&Schema{Type: "array"}))
+	tsq.Define("reject", &AddTask{}, ArgumentSchema(&Schema{Type: "string"}))
+
+	chain, err := tsq.SubmitChain([]string{"add", "count"}, 1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	WaitForStatus(t, tsq, chain.Jobs[1].UUID, JOB_SUCCESS)
+
+	_, err = tsq.SubmitBatch([]BatchJob{{"add", 1.0}}, "collect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-collect.results:
+	case <-time.After(time.Second):
+		t.Fatal("batch callback was not called")
+	}
+
+	chain, _ = tsq.SubmitChain([]string{"add", "reject"}, 1.0)
+	WaitForStatus(t, tsq, chain.Jobs[1].UUID, JOB_FAILURE)
+}
+
+type ProgressTask struct {
+	reported chan bool
+	forward  chan bool
+}
+
+func (tsk *ProgressTask) RunContext(ctx context.Context, args interface{}) (interface{}, error) {
+	err := ProgressReporter(ctx).Report(50, "copy", "half way")
+	tsk.reported <- true
+	<-tsk.forward
+	return nil, err
+}
+
+func TestProgressReporting(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsk := &ProgressTask{make(chan bool, 1), make(chan bool, 1)}
+	tsq.DefineContext("progress", tsk)
+	job, _ := tsq.Submit("progress", nil)
+	<-tsk.reported
+	job, _ = tsq.GetJob(job.UUID)
+	if job.Progress == nil || job.Progress.Percent != 50 || job.Progress.Step != "copy" || job.Progress.Message != "half way" {
+		t.Error("unexpected progress", job.Progress)
+	}
+	tsk.forward <- true
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
+
+	if err := ProgressReporter(context.Background()).Report(10, "", ""); err != nil {
+		t.Error("reporting outside of a job failed", err)
+	}
+}
+
+type LoggingTask struct{}
+
+func (tsk *LoggingTask) RunContext(ctx context.Context, args interface{}) (interface{}, error) {
+	logger := Logger(ctx)
+	for i := 0; i < 3; i++ {
+		logger.Printf("step %d", i)
+	}
+	logger.Println("done")
+	return nil, nil
+}
+
+func TestJobLogs(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.DefineContext("log", &LoggingTask{})
+	job, _ := tsq.Submit("log", nil)
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
+
+	lines, err := tsq.GetLogs(job.UUID, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0].Offset != 1 || lines[0].Message != "step 1" || lines[1].Message != "step 2" || lines[0].Time.IsZero() {
+		t.Error("unexpected log lines", lines)
+	}
+	lines, _ = tsq.GetLogs(job.UUID, 3, 10)
+	if len(lines) != 1 || lines[0].Message != "done" {
+		t.Error("unexpected last log line", lines)
+	}
+	if _, err := tsq.GetLogs("unknown", 0, 10); err == nil {
+		t.Error("got logs of an unknown job")
+	}
+	Logger(context.Background()).Println("discarded")
+}
+
+func TestSubscribe(t *testing.T) {
+	tsq := NewTestQueueWithConfig(Config{})
+	tsq.Define("add", &AddTask{})
+	events, unsubscribe := tsq.Subscribe("")
+	defer unsubscribe()
+	job, _ := tsq.Submit("add", 1.0)
+	WaitForStatus(t, tsq, job.UUID, JOB_SUCCESS)
+
+	var statuses []string
+	var result interface{}
+	for len(statuses) < 3 {
+		select {
+		case event := <-events:
+			if event.Job != job.UUID {
+				t.Error("unexpected event of another job", event)
+			}
+			switch event.Type {
+			case EVENT_STATUS:
+				statuses = append(statuses, event.Status)
+			case EVENT_RESULT:
+				result = event.Result
+			}
+		case <-time.After(time.Second):
+			t.Fatal("missing events", statuses)
+		}
+	}
+	if strings.Join(statuses, ",") != "PENDING,RUNNING,SUCCESS" || result != 2.0 {
+		t.Error("unexpected events", statuses, result)
+	}
+}
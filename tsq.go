@@ -1,6 +1,7 @@
 package tsq
 
 import (
+	"context"
 	"time"
 )
 
@@ -8,21 +9,64 @@ type Runner interface {
 	Run(args interface{}) (interface{}, error)
 }
 
+// ContextRunner is a Runner that can be stopped through its context. The
+// context is cancelled when the job is cancelled or the queue is stopped.
+type ContextRunner interface {
+	RunContext(ctx context.Context, args interface{}) (interface{}, error)
+}
+
+type runnerAdapter struct {
+	runner Runner
+}
+
+func (a runnerAdapter) RunContext(ctx context.Context, args interface{}) (interface{}, error) {
+	return a.runner.Run(args)
+}
+
+// AdaptRunner turns a Runner into a ContextRunner. Runners that already
+// implement ContextRunner are returned as is, others ignore the context.
+func AdaptRunner(r Runner) ContextRunner {
+	if cr, ok := r.(ContextRunner); ok {
+		return cr
+	}
+	return runnerAdapter{r}
+}
+
 type Job struct {
-	UUID      string      `json:"uuid"`
-	Name      string      `json:"name"`
-	Status    string      `json:"status"`
-	Arguments interface{} `json:"arguments"`
-	Result    interface{} `json:"result"`
-	Created   time.Time   `json:"created"`
-	Updated   time.Time   `json:"updated"`
+	UUID          string        `json:"uuid"`
+	Name          string        `json:"name"`
+	Queue         string        `json:"queue"`
+	Status        string        `json:"status"`
+	Arguments     interface{}   `json:"arguments"`
+	Result        interface{}   `json:"result"`
+	Attempts      int           `json:"attempts"`
+	Errors        []string      `json:"errors,omitempty"`
+	Timeout       time.Duration `json:"-"`
+	RunAt         time.Time     `json:"runAt"`
+	Priority      int           `json:"priority"`
+	Workflow      string        `json:"workflow,omitempty"`
+	Dependencies  []string      `json:"dependencies,omitempty"`
+	ArgumentsFrom string        `json:"argumentsFrom,omitempty"`
+	Batch         string        `json:"batch,omitempty"`
+	UniqueKey     string        `json:"uniqueKey,omitempty"`
+	Progress      *Progress     `json:"progress,omitempty"`
+	Created       time.Time     `json:"created"`
+	Updated       time.Time     `json:"updated"`
+	uniqueFor     time.Duration
+	validateOnRun bool
 }
 
 const (
-	JOB_PENDING = "PENDING"
-	JOB_RUNNING = "RUNNING"
-	JOB_SUCCESS = "SUCCESS"
-	JOB_FAILURE = "FAILURE"
+	JOB_WAITING   = "WAITING"
+	JOB_SCHEDULED = "SCHEDULED"
+	JOB_PENDING   = "PENDING"
+	JOB_RUNNING   = "RUNNING"
+	JOB_RETRYING  = "RETRYING"
+	JOB_SUCCESS   = "SUCCESS"
+	JOB_FAILURE   = "FAILURE"
+	JOB_CANCELLED = "CANCELLED"
+	JOB_TIMEOUT   = "TIMEOUT"
+	JOB_SKIPPED   = "SKIPPED"
 )
 
 type LifeCycle interface {
@@ -33,8 +77,34 @@ type LifeCycle interface {
 type JobStore interface {
 	LifeCycle
 	Store(job *Job) error
+	// StoreJobs stores either all of jobs or none of them.
+	StoreJobs(jobs []*Job) error
+	// StoreUnique stores a job unless another job holds its UniqueKey, in
+	// which case that job is returned. A key is held until the job holding
+	// it has finished and until has passed.
+	StoreUnique(job *Job, until time.Time) (*Job, error)
 	GetJob(uuid string) (*Job, error)
 	SetStatus(uuid string, status string, updated time.Time) error
 	SetResult(uuid string, result interface{}) error
+	SetProgress(uuid string, progress Progress) error
+	// AppendLog stores a log line of a job and sets its Offset.
+	AppendLog(uuid string, line *LogLine) error
+	GetLogs(uuid string, offset int, limit int) ([]LogLine, error)
+	SetAttempts(uuid string, attempts int, errors []string) error
 	GetJobs() ([]*Job, error)
+	GetJobsByStatus(statuses ...string) ([]*Job, error)
+	GetJobsByWorkflow(workflow string) ([]*Job, error)
+	StoreSchedule(schedule *Schedule) error
+	GetSchedules() ([]*Schedule, error)
+	StoreBatch(batch *Batch) error
+	GetBatch(id string) (*Batch, error)
+	GetJobsByBatch(id string) ([]*Job, error)
+	// GetUnsettledBatches returns the batches with an OnComplete task that
+	// has not been submitted yet.
+	GetUnsettledBatches() ([]*Batch, error)
+	SetBatchCallback(id string, uuid string) error
+	StoreIdempotencyKey(key *IdempotencyKey) error
+	GetIdempotencyKey(key string) (*IdempotencyKey, error)
+	// DeleteIdempotencyKeys removes the keys created before the given time.
+	DeleteIdempotencyKeys(before time.Time) error
 }
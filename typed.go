@@ -0,0 +1,73 @@
+package tsq
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// ValidationError is returned by Submit when the arguments of a job do not
+// fit its task.
+type ValidationError struct {
+	Task   string   `json:"task"`
+	Errors []string `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	return "Invalid arguments for task " + e.Task + ": " + strings.Join(e.Errors, "; ")
+}
+
+// DefineTyped registers a task whose arguments are decoded into A and whose
+// result is encoded from R. Both go through JSON, so the runner sees the same
+// values with every JobStore. Unless an ArgumentSchema is given, the schema
+// of the task is derived from A. Submit fails with a *ValidationError when
+// the arguments do not match it or cannot be decoded into A.
+func DefineTyped[A any, R any](q *TaskQueue, name string, run func(ctx context.Context, args A) (R, error), options ...TaskOption) {
+	var args A
+	options = append([]TaskOption{ArgumentSchema(SchemaFor(args))}, options...)
+	q.DefineContext(name, typedRunner[A, R]{name, run}, options...)
+	q.tasks[name].validate = func(arguments interface{}) error {
+		_, err := decodeArguments[A](name, arguments)
+		return err
+	}
+}
+
+type typedRunner[A any, R any] struct {
+	name string
+	run  func(ctx context.Context, args A) (R, error)
+}
+
+func (t typedRunner[A, R]) RunContext(ctx context.Context, arguments interface{}) (interface{}, error) {
+	args, err := decodeArguments[A](t.name, arguments)
+	if err != nil {
+		return nil, err
+	}
+	result, err := t.run(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return convertJSON(result)
+}
+
+func decodeArguments[A any](name string, arguments interface{}) (args A, err error) {
+	data, err := json.Marshal(arguments)
+	if err != nil {
+		return args, &ValidationError{name, []string{err.Error()}}
+	}
+	err = json.Unmarshal(data, &args)
+	if err != nil {
+		return args, &ValidationError{name, []string{err.Error()}}
+	}
+	return args, nil
+}
+
+// convertJSON turns a value into the generic form that encoding/json decodes
+// into, as a SQLiteStore would return it.
+func convertJSON(value interface{}) (converted interface{}, err error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &converted)
+	return
+}
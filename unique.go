@@ -0,0 +1,42 @@
+package tsq
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Unique deduplicates submissions of the same task with the same arguments.
+// See UniqueKey.
+func Unique(window time.Duration) SubmitOption {
+	return func(job *Job) {
+		job.UniqueKey = hashSubmission(job.Name, job.Arguments)
+		job.uniqueFor = window
+	}
+}
+
+func hashSubmission(name string, arguments interface{}) string {
+	data, _ := json.Marshal(arguments)
+	hash := sha256.Sum256(append([]byte(name+"\x00"), data...))
+	return hex.EncodeToString(hash[:])
+}
+
+// UniqueKey deduplicates submissions with the same key. While the job that
+// holds the key has not finished, or was submitted less than window ago,
+// submitting another job with that key returns the existing job instead.
+func UniqueKey(key string, window time.Duration) SubmitOption {
+	return func(job *Job) {
+		job.UniqueKey = key
+		job.uniqueFor = window
+	}
+}
+
+// store stores a new job. A job with a UniqueKey is only stored when no other
+// job holds that key, otherwise the job holding it is returned.
+func (q *TaskQueue) store(job *Job) (*Job, error) {
+	if job.UniqueKey == "" {
+		return job, q.jobStore.Store(job)
+	}
+	return q.jobStore.StoreUnique(job, job.Created.Add(job.uniqueFor))
+}
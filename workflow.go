@@ -0,0 +1,103 @@
+package tsq
+
+import (
+	"errors"
+	"time"
+)
+
+// Workflow groups jobs that depend on each other. A job of a workflow waits
+// until all of its dependencies have succeeded and is skipped as soon as one
+// of them ends in any other way.
+type Workflow struct {
+	ID    string
+	queue *TaskQueue
+}
+
+func (q *TaskQueue) NewWorkflow() (workflow *Workflow, err error) {
+	id, err := newUUID()
+	if err != nil {
+		return
+	}
+	workflow = &Workflow{ID: id, queue: q}
+	return
+}
+
+// Submit submits a job as part of the workflow.
+func (w *Workflow) Submit(name string, arguments interface{}, options ...SubmitOption) (*Job, error) {
+	return w.queue.Submit(name, arguments, append(options, inWorkflow(w.ID))...)
+}
+
+// GetWorkflow returns the jobs of a workflow in the order they were submitted.
+func (q *TaskQueue) GetWorkflow(id string) ([]*Job, error) {
+	return q.jobStore.GetJobsByWorkflow(id)
+}
+
+// DependsOn makes a job wait until the jobs with the given uuids succeeded.
+func DependsOn(uuids ...string) SubmitOption {
+	return func(job *Job) {
+		job.Dependencies = append(job.Dependencies, uuids...)
+	}
+}
+
+func inWorkflow(id string) SubmitOption {
+	return func(job *Job) {
+		job.Workflow = id
+	}
+}
+
+// submitWaiting stores a job with dependencies and decides whether it can be
+// queued right away.
+func (q *TaskQueue) submitWaiting(job *Job) (*Job, error) {
+	q.jobMutex.Lock()
+	defer q.jobMutex.Unlock()
+
+	for _, dependency := range job.Dependencies {
+		if _, err := q.jobStore.GetJob(dependency); err != nil {
+			return nil, errors.New("Unknown dependency: " + dependency)
+		}
+	}
+	job.Status = JOB_WAITING
+	stored, err := q.store(job)
+	if err != nil || stored != job {
+		return stored, err
+	}
+	q.waiting[job.UUID] = job
+	q.resolve(job)
+	return q.jobStore.GetJob(job.UUID)
+}
+
+// resolve queues a waiting job once all its dependencies succeeded and skips
+// it when one of them did not. The caller must hold q.jobMutex.
+func (q *TaskQueue) resolve(job *Job) {
+	for _, dependency := range job.Dependencies {
+		parent, err := q.jobStore.GetJob(dependency)
+		if err != nil {
+			q.skip(job, "Dependency "+dependency+" not found")
+			return
+		}
+		if !parent.HasFinished() {
+			return
+		}
+		if parent.Status != JOB_SUCCESS {
+			q.skip(job, "Dependency "+dependency+" ended with status "+parent.Status)
+			return
+		}
+	}
+
+	delete(q.waiting, job.UUID)
+	if job.RunAt.After(time.Now()) {
+		q.jobStore.SetStatus(job.UUID, JOB_SCHEDULED, time.Now())
+		q.dispatchAt(job, job.RunAt)
+		return
+	}
+	q.jobStore.SetStatus(job.UUID, JOB_PENDING, time.Now())
+	q.enqueue(job, time.Now(), false)
+}
+
+// skip ends a waiting job whose dependencies can no longer all succeed. The
+// caller must hold q.jobMutex.
+func (q *TaskQueue) skip(job *Job, reason string) {
+	delete(q.waiting, job.UUID)
+	q.jobStore.SetResult(job.UUID, reason)
+	q.finish(job.UUID, JOB_SKIPPED)
+}